@@ -0,0 +1,414 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/spf13/pflag"
+)
+
+var azblobFlagSet *pflag.FlagSet
+
+func init() {
+	azblobFlagSet = pflag.NewFlagSet("azblob", pflag.ContinueOnError)
+	azblobFlagSet.String("azblob-account", "", "name of the storage account")
+	azblobFlagSet.String(
+		"azblob-container",
+		"",
+		"name of the blob container (required)",
+	)
+	azblobFlagSet.String(
+		"azblob-blob",
+		"",
+		"name of the store blob (required)",
+	)
+	azblobFlagSet.String(
+		"azblob-connection-string",
+		"",
+		"connection string of the storage account",
+	)
+	azblobFlagSet.String(
+		"azblob-account-key",
+		"",
+		"shared key of the storage account",
+	)
+	azblobFlagSet.String(
+		"azblob-sas-token",
+		"",
+		"shared access signature (SAS) token",
+	)
+	azblobFlagSet.String(
+		"azblob-endpoint-url",
+		"",
+		"URL of the blob service (default from the account name)",
+	)
+	markSecret(
+		azblobFlagSet,
+		"azblob-connection-string",
+		"azblob-account-key",
+		"azblob-sas-token",
+	)
+}
+
+type azblobBackend struct {
+	container string
+	blob      string
+	client    *blockblob.Client
+}
+
+type azblobFactory struct{}
+
+func (f azblobFactory) New(conf map[string]interface{}) (Backend, error) {
+	return f.NewContext(context.Background(), conf)
+}
+
+func (f azblobFactory) NewContext(
+	ctx context.Context,
+	conf map[string]interface{},
+) (Backend, error) {
+	return newAzblob(ctx, conf)
+}
+
+func (f azblobFactory) Name() string {
+	return "Azure Blob"
+}
+
+func (f azblobFactory) Description() string {
+	return "store in an Azure Blob Storage blob"
+}
+
+func (f azblobFactory) Flags() *pflag.FlagSet {
+	return azblobFlagSet
+}
+
+func (f azblobFactory) Capabilities() []Capability {
+	return Capabilities(azblobBackend{})
+}
+
+func init() {
+	Backends["azblob"] = azblobFactory{}
+}
+
+func newAzblob(
+	ctx context.Context,
+	conf map[string]interface{},
+) (Backend, error) {
+	logger := getLogger(ctx)
+
+	var account, container, blobName string
+	var connString, accountKey, sasToken, endpoint string
+	opts := []struct {
+		name  string
+		label string
+		dest  *string
+	}{
+		{"account", "Azure account", &account},
+		{"container", "Azure container", &container},
+		{"blob", "Azure blob", &blobName},
+		{"connection-string", "Azure connection string", &connString},
+		{"account-key", "Azure account key", &accountKey},
+		{"sas-token", "Azure SAS token", &sasToken},
+		{"endpoint-url", "Azure endpoint URL", &endpoint},
+	}
+	for _, o := range opts {
+		opt := readOpt("azblob", o.name, conf)
+		if opt == nil || opt == "" {
+			continue
+		}
+		s, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a string: (%T)", o.label, opt)
+		}
+		*o.dest = s
+	}
+
+	if container == "" {
+		return nil, fmt.Errorf("missing Azure container")
+	}
+	if blobName == "" {
+		return nil, fmt.Errorf("missing Azure blob")
+	}
+
+	nCreds := 0
+	for _, c := range []string{connString, accountKey, sasToken} {
+		if c != "" {
+			nCreds++
+		}
+	}
+	if nCreds == 0 {
+		return nil, fmt.Errorf(
+			"missing Azure credentials: " +
+				"set a connection string, an account key or a SAS token",
+		)
+	}
+	if nCreds > 1 {
+		return nil, fmt.Errorf(
+			"only one of Azure connection string, " +
+				"account key and SAS token can be set",
+		)
+	}
+
+	var client *blockblob.Client
+	var err error
+	if connString != "" {
+		client, err = blockblob.NewClientFromConnectionString(
+			connString,
+			container,
+			blobName,
+			nil,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure connection string: %w", err)
+		}
+	} else {
+		if endpoint == "" {
+			if account == "" {
+				return nil, fmt.Errorf("missing Azure account")
+			}
+			endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+		}
+		blobURL, err := url.JoinPath(endpoint, container, blobName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure endpoint URL: %w", err)
+		}
+
+		if accountKey != "" {
+			if account == "" {
+				return nil, fmt.Errorf("Azure account key requires an account")
+			}
+			cred, err := blob.NewSharedKeyCredential(account, accountKey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Azure account key: %w", err)
+			}
+			client, err = blockblob.NewClientWithSharedKeyCredential(
+				blobURL,
+				cred,
+				nil,
+			)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			blobURL += "?" + strings.TrimPrefix(sasToken, "?")
+			client, err = blockblob.NewClientWithNoCredential(blobURL, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	logger.
+		WithField("container", container).
+		WithField("blob", blobName).
+		Info("using Azure Blob storage")
+
+	return azblobBackend{
+		container: container,
+		blob:      blobName,
+		client:    client,
+	}, nil
+}
+
+// isAzblobNotFound returns true if err is returned for a missing blob or
+// container.
+func isAzblobNotFound(err error) bool {
+	if bloberror.HasCode(
+		err,
+		bloberror.BlobNotFound,
+		bloberror.ContainerNotFound,
+	) {
+		return true
+	}
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) &&
+		respErr.StatusCode == http.StatusNotFound
+}
+
+// isAzblobConflict returns true if err is returned for a failed conditional
+// write.
+func isAzblobConflict(err error) bool {
+	return bloberror.HasCode(
+		err,
+		bloberror.ConditionNotMet,
+		bloberror.BlobAlreadyExists,
+	)
+}
+
+func (a azblobBackend) Exists() (bool, error) {
+	return a.ExistsContext(context.Background())
+}
+
+func (a azblobBackend) ExistsContext(ctx context.Context) (bool, error) {
+	logger := getLogger(ctx)
+	logger.
+		WithField("container", a.container).
+		WithField("blob", a.blob).
+		Info("checking store existence")
+
+	_, err := a.client.GetProperties(ctx, nil)
+	if err != nil {
+		if isAzblobNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (a azblobBackend) Save(data []byte) error {
+	return a.SaveContext(context.Background(), data)
+}
+
+func (a azblobBackend) SaveContext(ctx context.Context, data []byte) error {
+	return a.upload(ctx, data, nil)
+}
+
+func (a azblobBackend) Load() ([]byte, error) {
+	return a.LoadContext(context.Background())
+}
+
+func (a azblobBackend) LoadContext(ctx context.Context) ([]byte, error) {
+	data, _, err := a.LoadRevisionContext(ctx)
+	return data, err
+}
+
+// azblobNoETag is the revision of a store blob read without an ETag. ETags
+// are quoted, so it never matches one.
+const azblobNoETag Revision = "no-etag"
+
+func (a azblobBackend) LoadRevision() ([]byte, Revision, error) {
+	return a.LoadRevisionContext(context.Background())
+}
+
+func (a azblobBackend) LoadRevisionContext(
+	ctx context.Context,
+) ([]byte, Revision, error) {
+	logger := getLogger(ctx)
+	logger.
+		WithField("container", a.container).
+		WithField("blob", a.blob).
+		Info("reading encrypted data from Azure Blob storage")
+
+	res, err := a.client.DownloadStream(ctx, nil)
+	if err != nil {
+		if isAzblobNotFound(err) {
+			return nil, "", &NotFoundError{Err: err}
+		}
+		return nil, "", err
+	}
+	defer func() { _ = res.Body.Close() }()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if res.ETag == nil || *res.ETag == "" {
+		logger.Warn(
+			"service did not return an ETag, " +
+				"conflicting updates will not be detected",
+		)
+		return data, azblobNoETag, nil
+	}
+	return data, Revision(*res.ETag), nil
+}
+
+func (a azblobBackend) SaveRevision(data []byte, rev Revision) error {
+	return a.SaveRevisionContext(context.Background(), data, rev)
+}
+
+func (a azblobBackend) SaveRevisionContext(
+	ctx context.Context,
+	data []byte,
+	rev Revision,
+) error {
+	if rev == azblobNoETag {
+		return a.upload(ctx, data, nil)
+	}
+
+	cond := &blob.ModifiedAccessConditions{}
+	if rev == "" {
+		etag := azcore.ETagAny
+		cond.IfNoneMatch = &etag
+	} else {
+		etag := azcore.ETag(rev)
+		cond.IfMatch = &etag
+	}
+	return a.upload(ctx, data, cond)
+}
+
+func (a azblobBackend) Delete() error {
+	return a.DeleteContext(context.Background())
+}
+
+func (a azblobBackend) DeleteContext(ctx context.Context) error {
+	logger := getLogger(ctx)
+	logger.
+		WithField("container", a.container).
+		WithField("blob", a.blob).
+		Info("deleting store blob")
+
+	_, err := a.client.Delete(ctx, nil)
+	if err != nil {
+		if isAzblobNotFound(err) {
+			return &NotFoundError{Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// upload writes the store blob, with the conditions in cond if not nil.
+func (a azblobBackend) upload(
+	ctx context.Context,
+	data []byte,
+	cond *blob.ModifiedAccessConditions,
+) error {
+	logger := getLogger(ctx)
+	logger.
+		WithField("container", a.container).
+		WithField("blob", a.blob).
+		Info("writing encrypted data to Azure Blob storage")
+
+	opts := &blockblob.UploadOptions{}
+	if cond != nil {
+		opts.AccessConditions = &blob.AccessConditions{
+			ModifiedAccessConditions: cond,
+		}
+	}
+	_, err := a.client.Upload(
+		ctx,
+		streaming.NopCloser(bytes.NewReader(data)),
+		opts,
+	)
+	if err != nil {
+		if isAzblobConflict(err) {
+			return &ConflictError{Err: err}
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+)
+
+func TestAzblobFactory(t *testing.T) {
+	f := azblobFactory{}
+
+	testGenericFactory(t, f)
+
+	_, err := f.New(map[string]interface{}{
+		"azblob": map[string]interface{}{
+			"account":   "account",
+			"container": "container",
+			"blob":      "store.scrt",
+			"sas-token": "sv=2021-08-06&sig=abc",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	testCases := []map[string]interface{}{
+		{},
+		{
+			"azblob-account":   "account",
+			"azblob-blob":      "store.scrt",
+			"azblob-sas-token": "sig=abc",
+		},
+		{
+			"azblob-account":   "account",
+			"azblob-container": "container",
+			"azblob-sas-token": "sig=abc",
+		},
+		{
+			"azblob-account":   "account",
+			"azblob-container": "container",
+			"azblob-blob":      "store.scrt",
+		},
+		{
+			"azblob-container": "container",
+			"azblob-blob":      "store.scrt",
+			"azblob-sas-token": "sig=abc",
+		},
+		{
+			"azblob-account":     "account",
+			"azblob-container":   "container",
+			"azblob-blob":        "store.scrt",
+			"azblob-sas-token":   "sig=abc",
+			"azblob-account-key": "a2V5",
+		},
+		{
+			"azblob-account":     "account",
+			"azblob-container":   "container",
+			"azblob-blob":        "store.scrt",
+			"azblob-account-key": "not base64",
+		},
+		{
+			"azblob-container":         "container",
+			"azblob-blob":              "store.scrt",
+			"azblob-connection-string": "invalid",
+		},
+		{
+			"azblob-account":   12,
+			"azblob-container": "container",
+			"azblob-blob":      "store.scrt",
+			"azblob-sas-token": "sig=abc",
+		},
+	}
+	for i, conf := range testCases {
+		_, err := f.New(conf)
+		if err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
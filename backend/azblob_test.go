@@ -0,0 +1,335 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// azblobService is an in-memory stand-in for the Azure Blob service, storing
+// block blobs by path with their ETags. Signatures are not verified.
+type azblobService struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+	etags map[string]string
+	n     int
+	// auth returns false if the request is not authorized
+	auth func(r *http.Request) bool
+	// noETag makes the service read blobs without their ETags
+	noETag bool
+}
+
+func newAzblobService(t *testing.T) (*azblobService, string) {
+	t.Helper()
+
+	s := &azblobService{
+		blobs: map[string][]byte{},
+		etags: map[string]string{},
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server.URL
+}
+
+func (s *azblobService) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+}
+
+func (s *azblobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.auth != nil && !s.auth(r) {
+		s.fail(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+
+	etag, exists := s.etags[r.URL.Path]
+	w.Header().Set("x-ms-version", r.Header.Get("x-ms-version"))
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			s.fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		data := s.blobs[r.URL.Path]
+		if !s.noETag {
+			w.Header().Set("ETag", etag)
+		}
+		w.Header().Set(
+			"Last-Modified",
+			time.Now().UTC().Format(http.TimeFormat),
+		)
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodPut:
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			s.fail(w, http.StatusBadRequest, "InvalidHeaderValue")
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != etag {
+			s.fail(w, http.StatusPreconditionFailed, "ConditionNotMet")
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			s.fail(w, http.StatusConflict, "BlobAlreadyExists")
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.fail(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		s.n++
+		s.blobs[r.URL.Path] = data
+		s.etags[r.URL.Path] = fmt.Sprintf(`"0x%X"`, s.n)
+		w.Header().Set("ETag", s.etags[r.URL.Path])
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !exists {
+			s.fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(s.blobs, r.URL.Path)
+		delete(s.etags, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		s.fail(w, http.StatusMethodNotAllowed, "UnsupportedHttpVerb")
+	}
+}
+
+func TestAzblobSaveLoad(t *testing.T) {
+	_, endpoint := newAzblobService(t)
+
+	b, err := newAzblob(context.Background(), map[string]interface{}{
+		"azblob-endpoint-url": endpoint + "/account",
+		"azblob-container":    "container",
+		"azblob-blob":         "dir/store.scrt",
+		"azblob-sas-token":    "sv=2021-08-06&sig=abc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected store not to exist")
+	}
+	_, err = b.Load()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	for _, data := range []string{"v0", "v1"} {
+		err = b.Save([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]byte(data), got) {
+			t.Fatalf("expected %#v, got %#v", []byte(data), got)
+		}
+	}
+
+	exists, err = b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected store to exist")
+	}
+
+	err = b.(Deleter).Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.(Deleter).Delete()
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestAzblobSaveLoadRevision(t *testing.T) {
+	service, endpoint := newAzblobService(t)
+
+	b, err := newAzblob(context.Background(), map[string]interface{}{
+		"azblob-endpoint-url": endpoint + "/account",
+		"azblob-container":    "container",
+		"azblob-blob":         "store.scrt",
+		"azblob-sas-token":    "?sv=2021-08-06&sig=abc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := b.(ConditionalSaver)
+
+	err = cs.SaveRevision([]byte("v0"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.SaveRevision([]byte("v0"), "")
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	_, rev, err := cs.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.SaveRevision([]byte("v2"), rev)
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	_, rev, err = cs.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.SaveRevision([]byte("v2"), rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blobs read without an ETag are saved without conditions
+	service.noETag = true
+	_, rev, err = cs.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.SaveRevision([]byte("v3"), rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "v3" {
+		t.Fatalf("expected %q, got %q", "v3", got)
+	}
+}
+
+func TestAzblobAuth(t *testing.T) {
+	service, endpoint := newAzblobService(t)
+	accountKey := base64.StdEncoding.EncodeToString([]byte("s3cr3t"))
+
+	// Shared key
+	service.auth = func(r *http.Request) bool {
+		return strings.HasPrefix(
+			r.Header.Get("Authorization"),
+			"SharedKey account:",
+		)
+	}
+	b, err := newAzblob(context.Background(), map[string]interface{}{
+		"azblob-account":      "account",
+		"azblob-account-key":  accountKey,
+		"azblob-endpoint-url": endpoint + "/account",
+		"azblob-container":    "container",
+		"azblob-blob":         "store.scrt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Connection string
+	b, err = newAzblob(context.Background(), map[string]interface{}{
+		"azblob-connection-string": fmt.Sprintf(
+			"DefaultEndpointsProtocol=http;AccountName=account;"+
+				"AccountKey=%s;BlobEndpoint=%s/account;",
+			accountKey,
+			endpoint,
+		),
+		"azblob-container": "container",
+		"azblob-blob":      "store.scrt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("expected %q, got %q", "data", data)
+	}
+
+	// SAS token
+	service.auth = func(r *http.Request) bool {
+		return r.URL.Query().Get("sig") == "abc"
+	}
+	b, err = newAzblob(context.Background(), map[string]interface{}{
+		"azblob-endpoint-url": endpoint + "/account",
+		"azblob-container":    "container",
+		"azblob-blob":         "store.scrt",
+		"azblob-sas-token":    "sv=2021-08-06&sig=abc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = newAzblob(context.Background(), map[string]interface{}{
+		"azblob-endpoint-url": endpoint + "/account",
+		"azblob-container":    "container",
+		"azblob-blob":         "store.scrt",
+		"azblob-sas-token":    "sv=2021-08-06&sig=wrong",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Load()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var notFoundErr *NotFoundError
+	if errors.As(err, &notFoundErr) {
+		t.Fatalf("expected authentication error, got %v", err)
+	}
+}
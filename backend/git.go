@@ -16,23 +16,35 @@ package backend
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/go-git/go-git/v5/storage/memory"
-	"github.com/kevinburke/ssh_config"
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/afero"
 	"github.com/spf13/pflag"
 )
 
@@ -42,7 +54,11 @@ const defaultCommitMessage = "update secrets"
 
 func init() {
 	gitFlagSet = pflag.NewFlagSet("git", pflag.ContinueOnError)
-	gitFlagSet.String("git-url", "", "URL of the git repository (required)")
+	gitFlagSet.String(
+		"git-url",
+		"",
+		"URL of the git repository (required without local path)",
+	)
 	gitFlagSet.String(
 		"git-path",
 		"",
@@ -61,15 +77,113 @@ func init() {
 	gitFlagSet.String(
 		"git-message",
 		"",
-		"commit message when updating the store",
+		"commit message template when updating the store",
+	)
+	gitFlagSet.Bool(
+		"git-cache",
+		false,
+		"keep a clone of the repository on disk, and only fetch updates",
+	)
+	gitFlagSet.String(
+		"git-cache-dir",
+		"",
+		"directory of the repository cache (default user cache directory)",
+	)
+	gitFlagSet.Bool(
+		"git-offline",
+		false,
+		"read the store from the repository cache without fetching",
+	)
+	gitFlagSet.String(
+		"git-local-path",
+		"",
+		"path to an existing working copy of the repository, instead of a URL",
+	)
+	gitFlagSet.Bool(
+		"git-commit",
+		true,
+		"commit changes to the local repository",
+	)
+	gitFlagSet.Bool(
+		"git-push",
+		true,
+		"push commits to the remote repository",
+	)
+	gitFlagSet.String(
+		"git-username",
+		"",
+		"username for HTTP(S) authentication",
+	)
+	gitFlagSet.String(
+		"git-token",
+		"",
+		"password or access token for HTTP(S) authentication",
+	)
+	gitFlagSet.String(
+		"git-known-hosts",
+		"",
+		"path to the SSH known hosts file (default from SSH config)",
+	)
+	gitFlagSet.String(
+		"git-ssh-key",
+		"",
+		"path to the SSH private key (default identity files from SSH config)",
+	)
+	gitFlagSet.String(
+		"git-ssh-key-passphrase",
+		"",
+		"passphrase of the SSH private keys",
+	)
+	gitFlagSet.String(
+		"git-author-name",
+		"",
+		"name of the commit author (default from git config)",
+	)
+	gitFlagSet.String(
+		"git-author-email",
+		"",
+		"email of the commit author (default from git config)",
+	)
+	gitFlagSet.String(
+		"git-signing-key",
+		"",
+		"path to an OpenPGP or SSH private key to sign commits",
+	)
+	gitFlagSet.String(
+		"git-signing-key-passphrase",
+		"",
+		"passphrase of the commit signing key",
+	)
+	markSecret(
+		gitFlagSet,
+		"git-token",
+		"git-ssh-key-passphrase",
+		"git-signing-key-passphrase",
 	)
 }
 
 type gitBackend struct {
-	path    string
-	message string
-	repo    *git.Repository
-	fs      billy.Filesystem
+	url        string
+	branch     string
+	checkedOut string
+	path       string
+	message    string
+	template   *template.Template
+	offline    bool
+	shallow    bool
+	localPath  string
+	noCommit   bool
+	noPush     bool
+	username   string
+	token      string
+	sshAuth    sshAuth
+	author     object.Signature
+	signer     git.Signer
+	repo       *git.Repository
+	fs         billy.Filesystem
+	auth       transport.AuthMethod
+	// cacheLock is the lock on the cached clone, if any
+	cacheLock *cacheLock
 }
 
 type gitFactory struct{}
@@ -97,6 +211,10 @@ func (f gitFactory) Flags() *pflag.FlagSet {
 	return gitFlagSet
 }
 
+func (f gitFactory) Capabilities() []Capability {
+	return Capabilities(gitBackend{})
+}
+
 func init() {
 	Backends["git"] = gitFactory{}
 }
@@ -104,19 +222,43 @@ func init() {
 func newGit(ctx context.Context, conf map[string]interface{}) (Backend, error) {
 	logger := getLogger(ctx)
 
-	opt := readOpt("git", "url", conf)
-	if opt == nil || opt == "" {
+	var localPath string
+	var err error
+	opt := readOpt("git", "local-path", conf)
+	if opt != nil && opt != "" {
+		var ok bool
+		localPath, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"local path is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+		localPath, err = homedir.Expand(localPath)
+		if err != nil {
+			return nil, err
+		}
+		logger = logger.WithField("local_path", localPath)
+	}
+
+	var url string
+	opt = readOpt("git", "url", conf)
+	if (opt == nil || opt == "") && localPath == "" {
 		return nil, fmt.Errorf("missing repository URL")
 	}
-	url, ok := opt.(string)
-	if !ok {
-		return nil, fmt.Errorf(
-			"repository URL is not a string: (%T)%s",
-			opt,
-			opt,
-		)
+	if opt != nil && opt != "" {
+		var ok bool
+		url, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"repository URL is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+		logger = logger.WithField("url", url)
 	}
-	logger = logger.WithField("url", url)
 
 	opt = readOpt("git", "path", conf)
 	if opt == nil || opt == "" {
@@ -148,27 +290,219 @@ func newGit(ctx context.Context, conf map[string]interface{}) (Backend, error) {
 		logger = logger.WithField("checkout", checkout)
 	}
 
-	message := defaultCommitMessage
+	messageText := defaultCommitMessage
 	opt = readOpt("git", "message", conf)
 	if opt != nil && opt != "" {
-		message, ok = opt.(string)
+		messageText, ok = opt.(string)
 		if !ok {
 			return nil, fmt.Errorf("message is not a string: (%T)%s", opt, opt)
 		}
 	}
+	// Messages that are not valid templates, e.g. written before messages were
+	// templates, are used as is
+	message, err := template.New("message").Parse(messageText)
+	if err != nil {
+		logger.
+			WithError(err).
+			Warn("invalid message template, using the message as is")
+		message = nil
+	}
+
+	var useCache, offline bool
+	opt = readOpt("git", "cache", conf)
+	if opt != nil {
+		useCache, err = toBool(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache option: %w", err)
+		}
+	}
+	opt = readOpt("git", "offline", conf)
+	if opt != nil {
+		offline, err = toBool(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offline option: %w", err)
+		}
+		// Offline mode reads from the cache
+		useCache = useCache || offline
+	}
+
+	commitChanges, pushChanges := true, true
+	opt = readOpt("git", "commit", conf)
+	if opt != nil {
+		commitChanges, err = toBool(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit option: %w", err)
+		}
+	}
+	opt = readOpt("git", "push", conf)
+	if opt != nil {
+		pushChanges, err = toBool(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid push option: %w", err)
+		}
+	}
+	// Changes that are not committed cannot be pushed
+	pushChanges = pushChanges && commitChanges
+
+	if localPath != "" {
+		if useCache {
+			return nil, fmt.Errorf("cannot use cache with a local path")
+		}
+		if checkout != "" {
+			return nil, fmt.Errorf("cannot checkout a revision in a local path")
+		}
+	} else if !pushChanges {
+		// Changes would be lost with the in-memory or cached clone
+		return nil, fmt.Errorf(
+			"changes can only be kept unpushed in a local path",
+		)
+	}
+
+	var cacheDir string
+	if useCache {
+		opt = readOpt("git", "cache-dir", conf)
+		if opt != nil {
+			cacheDir, ok = opt.(string)
+			if !ok {
+				return nil, fmt.Errorf(
+					"cache directory is not a string: (%T)%s",
+					opt,
+					opt,
+				)
+			}
+		} else {
+			cacheDir, err = os.UserCacheDir()
+			if err != nil {
+				return nil, err
+			}
+			cacheDir = filepath.Join(cacheDir, "scrt", "git")
+		}
+		cacheDir, err = homedir.Expand(cacheDir)
+		if err != nil {
+			return nil, err
+		}
+		logger = logger.WithField("cache_dir", cacheDir)
+	}
+
+	var username, token string
+	opt = readOpt("git", "username", conf)
+	if opt != nil && opt != "" {
+		username, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("username is not a string: (%T)%s", opt, opt)
+		}
+		logger = logger.WithField("username", username)
+	}
+	opt = readOpt("git", "token", conf)
+	if opt != nil && opt != "" {
+		token, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("token is not a string: (%T)", opt)
+		}
+	}
+
+	sshAuth, err := readSSHAuth("git", conf)
+	if err != nil {
+		return nil, err
+	}
+	if sshAuth.knownHosts != "" {
+		logger = logger.WithField("known_hosts", sshAuth.knownHosts)
+	}
+	if sshAuth.key != "" {
+		logger = logger.WithField("ssh_key", sshAuth.key)
+	}
+
+	var author object.Signature
+	opt = readOpt("git", "author-name", conf)
+	if opt != nil && opt != "" {
+		author.Name, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"author name is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+	}
+	opt = readOpt("git", "author-email", conf)
+	if opt != nil && opt != "" {
+		author.Email, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"author email is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+	}
+
+	var signer git.Signer
+	opt = readOpt("git", "signing-key", conf)
+	if opt != nil && opt != "" {
+		signingKey, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"signing key is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+		signingKey, err = homedir.Expand(signingKey)
+		if err != nil {
+			return nil, err
+		}
+		var passphrase string
+		opt = readOpt("git", "signing-key-passphrase", conf)
+		if opt != nil && opt != "" {
+			passphrase, ok = opt.(string)
+			if !ok {
+				return nil, fmt.Errorf(
+					"signing key passphrase is not a string: (%T)",
+					opt,
+				)
+			}
+		}
+		signer, err = loadSigner(signingKey, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("could not load signing key: %w", err)
+		}
+		logger = logger.WithField("signing_key", signingKey)
+	}
 
 	logger.Info("using git repository")
 
 	g := gitBackend{
-		path:    path,
-		message: message,
+		url:        url,
+		branch:     branch,
+		checkedOut: checkout,
+		path:       path,
+		message:    messageText,
+		template:   message,
+		offline:    offline,
+		localPath:  localPath,
+		noCommit:   !commitChanges,
+		noPush:     !pushChanges,
+		username:   username,
+		token:      token,
+		sshAuth:    sshAuth,
+		author:     author,
+		signer:     signer,
+		// The full history is only needed to checkout an older revision
+		shallow: checkout == "",
 	}
 
-	err := g.clone(ctx, url, branch)
-	// If the repo is empty, init a new repo
-	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
-		logger.Info("repository is empty")
-		err = g.init(ctx, url, branch)
+	if localPath != "" {
+		err = g.openLocal(ctx, localPath, branch)
+	} else if useCache {
+		err = g.openCache(ctx, url, branch, cacheDir)
+	} else {
+		storer, fs := memory.NewStorage(), memfs.New()
+		err = g.clone(ctx, url, branch, storer, fs)
+		// If the repo is empty, init a new repo
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			logger.Info("repository is empty")
+			err = g.init(ctx, url, branch, memory.NewStorage(), memfs.New())
+		}
 	}
 	if err != nil {
 		return nil, err
@@ -177,6 +511,7 @@ func newGit(ctx context.Context, conf map[string]interface{}) (Backend, error) {
 	if checkout != "" {
 		err = g.checkout(ctx, checkout)
 		if err != nil {
+			_ = g.Close()
 			return nil, err
 		}
 	}
@@ -184,6 +519,14 @@ func newGit(ctx context.Context, conf map[string]interface{}) (Backend, error) {
 	return g, nil
 }
 
+// Close releases the lock on the cached clone of the repository, if any.
+func (g gitBackend) Close() error {
+	if g.cacheLock == nil {
+		return nil
+	}
+	return g.cacheLock.release()
+}
+
 func (g gitBackend) Exists() (bool, error) {
 	return g.ExistsContext(context.Background())
 }
@@ -210,79 +553,19 @@ func (g gitBackend) Save(data []byte) error {
 }
 
 func (g gitBackend) SaveContext(ctx context.Context, data []byte) error {
-	logger := getLogger(ctx)
-
-	logger = logger.WithField("path", g.path)
-
-	logger.Info("opening file in git repository")
-	f, err := g.fs.OpenFile(g.path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o700)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = f.Close() }()
-
-	logger.Info("writing encrypted data to git repository")
-	n, err := f.Write(data)
-	if err != nil {
-		return err
-	}
-
-	if n != len(data) {
-		return fmt.Errorf("wrote %d bytes, expected %d", n, len(data))
-	}
-	err = f.Close()
+	err := g.checkWritable(ctx)
 	if err != nil {
 		return err
 	}
 
-	w, err := g.repo.Worktree()
-	if err != nil {
-		return err
-	}
-
-	logger.Info("staging file in worktree")
-	_, err = w.Add(g.path)
-	if err != nil {
-		return err
-	}
-
-	gitConfig, err := g.repo.ConfigScoped(config.SystemScope)
-	if err != nil {
-		return err
-	}
-	user := gitConfig.User
-	authorCommitter := &object.Signature{
-		Name:  user.Name,
-		Email: user.Email,
-		When:  time.Now(),
-	}
-
-	logger.
-		WithField(
-			"committer",
-			fmt.Sprintf("%s <%s>", authorCommitter.Name, authorCommitter.Email),
-		).
-		Infof("committing changes to git repository: \"%s\"", g.message)
-	_, err = w.Commit(
-		g.message,
-		&git.CommitOptions{
-			Author:    authorCommitter,
-			Committer: authorCommitter,
-		},
-	)
+	err = g.commit(ctx, data)
 	if err != nil {
 		return err
 	}
-
-	logger.Info("pushing changes to git remote")
-	err = g.repo.Push(&git.PushOptions{
-		RemoteName: git.DefaultRemoteName,
-	})
-	if err != nil {
-		return err
+	if g.noPush {
+		return nil
 	}
-
-	return nil
+	return g.push(ctx)
 }
 
 func (g gitBackend) Load() ([]byte, error) {
@@ -297,6 +580,9 @@ func (g gitBackend) LoadContext(ctx context.Context) ([]byte, error) {
 		Info("reading encrypted data from git repository")
 
 	f, err := g.fs.OpenFile(g.path, os.O_RDONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &NotFoundError{Err: err}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -310,156 +596,1032 @@ func (g gitBackend) LoadContext(ctx context.Context) ([]byte, error) {
 	return data, nil
 }
 
-func (g *gitBackend) clone(ctx context.Context, url, branch string) error {
+func (g gitBackend) LoadRevision() ([]byte, Revision, error) {
+	return g.LoadRevisionContext(context.Background())
+}
+
+func (g gitBackend) LoadRevisionContext(
+	ctx context.Context,
+) ([]byte, Revision, error) {
+	data, err := g.LoadContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	rev, err := g.revision()
+	if err != nil {
+		return nil, "", err
+	}
+	return data, rev, nil
+}
+
+func (g gitBackend) SaveRevision(data []byte, rev Revision) error {
+	return g.SaveRevisionContext(context.Background(), data, rev)
+}
+
+func (g gitBackend) SaveRevisionContext(
+	ctx context.Context,
+	data []byte,
+	rev Revision,
+) error {
 	logger := getLogger(ctx)
-	auths, err := buildAuths(ctx, url)
+
+	err := g.checkWritable(ctx)
 	if err != nil {
 		return err
 	}
 
-	logger = logger.WithField("url", url)
-	storage := memory.NewStorage()
-	g.fs = memfs.New()
-	var referenceName plumbing.ReferenceName
-	if branch != "" {
-		logger = logger.WithField("branch", branch)
-		referenceName = plumbing.NewBranchReferenceName(branch)
+	cur, err := g.revision()
+	if err != nil {
+		return err
+	}
+	if cur != rev {
+		return &ConflictError{}
 	}
 
-	logger.Info("cloning git repository in memory")
-	if len(auths) > 0 {
-		for _, auth := range auths {
-			g.repo, err = git.Clone(
-				storage,
-				g.fs,
-				&git.CloneOptions{
-					URL:           url,
-					ReferenceName: referenceName,
-					Auth:          auth,
-				},
-			)
-			if err == nil {
-				return nil
-			}
-		}
-	} else {
-		g.repo, err = git.Clone(
-			storage,
-			g.fs,
-			&git.CloneOptions{
-				URL:           url,
-				ReferenceName: referenceName,
-			},
-		)
+	err = g.commit(ctx, data)
+	if err != nil {
+		return err
+	}
+	if g.noPush {
+		return nil
 	}
 
+	err = g.push(ctx)
+	if isNonFastForward(err) && g.localPath != "" {
+		// Never reset a working copy, let the user merge the changes
+		return fmt.Errorf(
+			"%w: the changes were committed to the local repository, "+
+				"pull the remote changes and push them",
+			err,
+		)
+	}
+	if isNonFastForward(err) {
+		// The remote has new commits: drop the local commit and catch up with
+		// the remote so that the store can be reloaded
+		logger.Info("remote was updated concurrently")
+		resetErr := g.fetch(ctx)
+		if resetErr == nil {
+			resetErr = g.resetToRemote(ctx, "")
+		}
+		if resetErr != nil {
+			return resetErr
+		}
+		return &ConflictError{Err: err}
+	}
 	return err
 }
 
-func (g *gitBackend) init(ctx context.Context, url, branch string) error {
+func (g gitBackend) Delete() error {
+	return g.DeleteContext(context.Background())
+}
+
+// DeleteContext removes the store file from the repository, and commits and
+// pushes the removal. The store can still be read in the history of the
+// repository.
+func (g gitBackend) DeleteContext(ctx context.Context) error {
 	logger := getLogger(ctx)
-	var err error
 
-	logger.Info("initializing git repository in memory")
-	storage := memory.NewStorage()
-	g.fs = memfs.New()
-	g.repo, err = git.Init(storage, g.fs)
+	err := g.checkWritable(ctx)
 	if err != nil {
 		return err
 	}
 
-	logger.WithField("url", url).Info("adding git remote")
-	_, err = g.repo.CreateRemote(&config.RemoteConfig{
-		Name: git.DefaultRemoteName,
-		URLs: []string{url},
-	})
+	w, err := g.repo.Worktree()
 	if err != nil {
 		return err
 	}
 
-	// Set default branch name, if not configured
-	if branch == "" {
-		branch = "main"
-		gitConfig, err := g.repo.ConfigScoped(config.SystemScope)
-		if err == nil {
-			n := gitConfig.Init.DefaultBranch
-			if n != "" {
-				branch = n
-			}
+	if g.localPath != "" && !g.noCommit {
+		err = g.checkStaged(w)
+		if err != nil {
+			return err
 		}
 	}
-	logger.WithField("branch", branch).Info("using git branch")
 
-	ref := plumbing.NewSymbolicReference(
+	_, err = g.fs.Stat(g.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &NotFoundError{Err: err}
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.
+		WithField("path", g.path).
+		Info("removing file from git repository")
+	_, err = w.Remove(g.path)
+	if err != nil {
+		return err
+	}
+	if g.noCommit {
+		return nil
+	}
+
+	err = g.commitWorktree(ctx, w)
+	if err != nil {
+		return err
+	}
+	if g.noPush {
+		return nil
+	}
+	return g.push(ctx)
+}
+
+var errOffline = errors.New("cannot update the store in offline mode")
+
+// checkWritable returns an error if the store cannot be updated, because the
+// repository is offline or a past revision is checked out. Otherwise, the
+// cached clone, if any, is locked exclusively for the update.
+func (g gitBackend) checkWritable(ctx context.Context) error {
+	if g.offline {
+		return errOffline
+	}
+	if g.checkedOut != "" {
+		return fmt.Errorf(
+			"cannot update the store checked out at revision \"%s\"",
+			g.checkedOut,
+		)
+	}
+	if g.cacheLock != nil {
+		return g.cacheLock.lock(ctx, false)
+	}
+	return nil
+}
+
+func (g gitBackend) ListStores() ([]string, error) {
+	return g.ListStoresContext(context.Background())
+}
+
+// ListStoresContext lists the files in the directory of the store in the
+// repository, or in the store path itself if it is a directory, and in their
+// subdirectories.
+func (g gitBackend) ListStoresContext(ctx context.Context) ([]string, error) {
+	dir := g.path
+	fi, err := g.fs.Stat(g.path)
+	if err != nil || !fi.IsDir() {
+		dir = filepath.Dir(g.path)
+	}
+
+	logger := getLogger(ctx)
+	logger.WithField("path", dir).Info("listing stores in repository")
+
+	var names []string
+	err = util.Walk(g.fs, dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() && fi.Name() == git.GitDirName {
+			return filepath.SkipDir
+		}
+		if fi.Mode().IsRegular() {
+			names = append(names, filepath.ToSlash(filepath.Clean(p)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filterStores(names), nil
+}
+
+func (g gitBackend) ListVersions() ([]Version, error) {
+	return g.ListVersionsContext(context.Background())
+}
+
+func (g gitBackend) ListVersionsContext(
+	ctx context.Context,
+) ([]Version, error) {
+	logger := getLogger(ctx)
+
+	repo, err := g.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logger.
+		WithField("path", g.path).
+		Info("listing commits modifying the store")
+	iter, err := repo.Log(&git.LogOptions{
+		From:     head.Hash(),
+		FileName: &g.path,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	err = iter.ForEach(func(c *object.Commit) error {
+		f, err := c.File(g.path)
+		if errors.Is(err, object.ErrFileNotFound) {
+			// The store was deleted in this commit
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		versions = append(versions, Version{
+			ID:      c.Hash.String(),
+			ModTime: c.Committer.When,
+			Size:    f.Size,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (g gitBackend) LoadVersion(version string) ([]byte, error) {
+	return g.LoadVersionContext(context.Background(), version)
+}
+
+func (g gitBackend) LoadVersionContext(
+	ctx context.Context,
+	version string,
+) ([]byte, error) {
+	logger := getLogger(ctx)
+
+	repo, err := g.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.
+		WithField("revision", version).
+		Info("resolving revision to commit hash")
+	hash, err := repo.ResolveRevision(plumbing.Revision(version))
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve \"%s\": %w", version, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	return g.readCommit(ctx, commit)
+}
+
+func (g gitBackend) LoadAt(t time.Time) ([]byte, error) {
+	return g.LoadAtContext(context.Background(), t)
+}
+
+func (g gitBackend) LoadAtContext(
+	ctx context.Context,
+	t time.Time,
+) ([]byte, error) {
+	logger := getLogger(ctx)
+
+	repo, err := g.history(ctx)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.
+		WithField("time", t).
+		Info("looking for the last commit before time")
+	iter, err := repo.Log(&git.LogOptions{
+		From:  head.Hash(),
+		Order: git.LogOrderCommitterTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var commit *object.Commit
+	err = iter.ForEach(func(c *object.Commit) error {
+		if !c.Committer.When.After(t) {
+			commit = c
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if commit == nil {
+		return nil, fmt.Errorf("no commit before %s", t.Format(time.RFC3339))
+	}
+	return g.readCommit(ctx, commit)
+}
+
+// history returns a repository with the full history of the branch. A
+// shallow clone is completed by cloning the repository again in memory.
+func (g gitBackend) history(ctx context.Context) (*git.Repository, error) {
+	logger := getLogger(ctx)
+
+	shallow, err := g.repo.Storer.Shallow()
+	if err != nil {
+		return nil, err
+	}
+	if len(shallow) == 0 {
+		return g.repo, nil
+	}
+	if g.offline {
+		return nil, fmt.Errorf("history is not cached, cannot read offline")
+	}
+
+	logger.Info("cloning full history of git repository")
+	full := g
+	full.shallow = false
+	err = full.clone(ctx, g.url, g.branch, memory.NewStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return full.repo, nil
+}
+
+// readCommit reads the store in the tree of commit.
+func (g gitBackend) readCommit(
+	ctx context.Context,
+	commit *object.Commit,
+) ([]byte, error) {
+	logger := getLogger(ctx)
+
+	logger.
+		WithField("hash", commit.Hash).
+		WithField("path", g.path).
+		Info("reading store at commit")
+	f, err := commit.File(g.path)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, fmt.Errorf(
+			"store does not exist at commit %s",
+			commit.Hash,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+// revision returns the hash of the commit at HEAD, or the empty revision if
+// the repository has no commits.
+func (g gitBackend) revision() (Revision, error) {
+	head, err := g.repo.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return Revision(head.Hash().String()), nil
+}
+
+// fetch fetches the remote branches.
+func (g gitBackend) fetch(ctx context.Context) error {
+	logger := getLogger(ctx)
+
+	opts := &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		Auth:       g.auth,
+	}
+	if g.shallow {
+		opts.Depth = 1
+	}
+
+	logger.Info("fetching changes from git remote")
+	err := g.repo.FetchContext(ctx, opts)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+	return nil
+}
+
+// resetToRemote hard resets branch to the remote branch, and checks it out. If
+// branch is empty, the current branch is reset.
+func (g gitBackend) resetToRemote(ctx context.Context, branch string) error {
+	logger := getLogger(ctx)
+
+	if branch == "" {
+		var err error
+		branch, err = g.currentBranch()
+		if err != nil {
+			return err
+		}
+	}
+
+	remoteRef, err := g.repo.Reference(
+		plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch),
+		true,
+	)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			// Nothing to reset to, the branch was never pushed
+			return nil
+		}
+		return err
+	}
+
+	err = g.repo.Storer.SetReference(plumbing.NewSymbolicReference(
 		plumbing.HEAD,
 		plumbing.NewBranchReferenceName(branch),
+	))
+	if err != nil {
+		return err
+	}
+
+	w, err := g.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	logger.
+		WithField("branch", branch).
+		WithField("hash", remoteRef.Hash()).
+		Info("resetting worktree to remote branch")
+	return w.Reset(&git.ResetOptions{
+		Commit: remoteRef.Hash(),
+		Mode:   git.HardReset,
+	})
+}
+
+// errDetachedHead is returned when HEAD is not on a branch, and the store
+// cannot be committed to a branch.
+var errDetachedHead = errors.New("HEAD is detached, check out a branch")
+
+// currentBranch returns the name of the branch checked out at HEAD, or
+// errDetachedHead if HEAD is detached.
+func (g gitBackend) currentBranch() (string, error) {
+	ref, err := g.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+	if ref.Type() != plumbing.SymbolicReference {
+		return "", errDetachedHead
+	}
+	return ref.Target().Short(), nil
+}
+
+// isNonFastForward returns true if err is the result of pushing to a remote
+// branch that has diverged.
+func isNonFastForward(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, git.ErrNonFastForwardUpdate) ||
+		strings.Contains(err.Error(), "non-fast-forward") ||
+		strings.Contains(err.Error(), "fetch first")
+}
+
+func (g *gitBackend) clone(
+	ctx context.Context,
+	url, branch string,
+	storer storage.Storer,
+	fs billy.Filesystem,
+) error {
+	logger := getLogger(ctx)
+	auths, err := g.buildAuths(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	logger = logger.WithField("url", url)
+	g.fs = fs
+	var referenceName plumbing.ReferenceName
+	if branch != "" {
+		logger = logger.WithField("branch", branch)
+		referenceName = plumbing.NewBranchReferenceName(branch)
+	}
+
+	opts := &git.CloneOptions{
+		URL:           url,
+		ReferenceName: referenceName,
+	}
+	if g.shallow {
+		logger = logger.WithField("depth", 1)
+		opts.Depth = 1
+		opts.SingleBranch = true
+	}
+
+	logger.Info("cloning git repository")
+	if len(auths) > 0 {
+		for _, auth := range auths {
+			opts.Auth = auth
+			g.repo, err = git.CloneContext(ctx, storer, g.fs, opts)
+			if err == nil ||
+				errors.Is(err, transport.ErrEmptyRemoteRepository) {
+				g.auth = auth
+				return err
+			}
+		}
+	} else {
+		g.repo, err = git.CloneContext(ctx, storer, g.fs, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if g.shallow && branch == "" {
+		return g.trackCurrentBranch()
+	}
+	return nil
+}
+
+// trackCurrentBranch configures the remote to fetch the branch checked out at
+// HEAD. A single-branch clone without an explicit branch only tracks the
+// remote HEAD, which cannot be pushed to or reset to.
+func (g *gitBackend) trackCurrentBranch() error {
+	branch, err := g.currentBranch()
+	if err != nil {
+		return err
+	}
+
+	remoteHead, err := g.repo.Reference(
+		plumbing.NewRemoteReferenceName(git.DefaultRemoteName, "HEAD"),
+		true,
+	)
+	if err != nil {
+		return err
+	}
+	remoteRefName := plumbing.NewRemoteReferenceName(
+		git.DefaultRemoteName,
+		branch,
+	)
+	err = g.repo.Storer.SetReference(
+		plumbing.NewHashReference(remoteRefName, remoteHead.Hash()),
 	)
-	err = storage.SetReference(ref)
 	if err != nil {
 		return err
 	}
-
+
+	cfg, err := g.repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Remotes[git.DefaultRemoteName].Fetch = []config.RefSpec{
+		config.RefSpec(fmt.Sprintf(
+			"+%s:%s",
+			plumbing.NewBranchReferenceName(branch),
+			remoteRefName,
+		)),
+	}
+	return g.repo.SetConfig(cfg)
+}
+
+func (g *gitBackend) init(
+	ctx context.Context,
+	url, branch string,
+	storer storage.Storer,
+	fs billy.Filesystem,
+) error {
+	logger := getLogger(ctx)
+	var err error
+
+	logger.Info("initializing git repository")
+	g.fs = fs
+	g.repo, err = git.Init(storer, g.fs)
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("url", url).Info("adding git remote")
+	_, err = g.repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Set default branch name, if not configured
+	if branch == "" {
+		branch = "main"
+		gitConfig, err := g.repo.ConfigScoped(config.SystemScope)
+		if err == nil {
+			n := gitConfig.Init.DefaultBranch
+			if n != "" {
+				branch = n
+			}
+		}
+	}
+	logger.WithField("branch", branch).Info("using git branch")
+
+	ref := plumbing.NewSymbolicReference(
+		plumbing.HEAD,
+		plumbing.NewBranchReferenceName(branch),
+	)
+	err = storer.SetReference(ref)
+	if err != nil {
+		return err
+	}
+
+	return g.repo.CreateBranch(&config.Branch{
+		Name:   branch,
+		Remote: git.DefaultRemoteName,
+		Merge:  plumbing.NewBranchReferenceName(branch),
+	})
+}
+
+// openLocal opens the existing repository with a working copy at path. The
+// working copy must be on a branch, and on branch if it is not empty.
+func (g *gitBackend) openLocal(ctx context.Context, path, branch string) error {
+	logger := getLogger(ctx)
+
+	logger.Info("opening local git repository")
+	repo, err := git.PlainOpenWithOptions(
+		path,
+		&git.PlainOpenOptions{DetectDotGit: true},
+	)
+	if err != nil {
+		return err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	g.repo = repo
+	g.fs = w.Filesystem
+
+	current, err := g.currentBranch()
+	if err != nil {
+		return fmt.Errorf("local repository: %w", err)
+	}
+	if branch != "" && current != branch {
+		return fmt.Errorf(
+			"local repository is on branch \"%s\", not \"%s\"",
+			current,
+			branch,
+		)
+	}
+	return nil
+}
+
+// cacheLock is the lock on a cached clone shared with other processes. It is
+// shared by the backends reading the clone, and exclusive while the clone is
+// updated.
+type cacheLock struct {
+	path   string
+	shared bool
+	unlock func() error
+}
+
+// lock acquires the lock, shared or exclusive. A lock already held is
+// released first, since a lock cannot be upgraded without releasing it.
+func (l *cacheLock) lock(ctx context.Context, shared bool) error {
+	if l.unlock != nil && l.shared == shared {
+		return nil
+	}
+	err := l.release()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(
+		ctx,
+		afero.NewOsFs(),
+		l.path,
+		shared,
+		defaultLockTimeout,
+	)
+	if err != nil {
+		return fmt.Errorf("could not lock cached repository: %w", err)
+	}
+	l.shared = shared
+	l.unlock = unlock
+	return nil
+}
+
+// open locks the clone to open it. The clone is locked exclusively to update
+// it, unless it is only read, or other processes are using it: they updated it
+// when they opened it, and it is read as is with a shared lock. Returns
+// whether the clone can be updated.
+func (l *cacheLock) open(ctx context.Context, readOnly bool) (bool, error) {
+	if !readOnly {
+		unlock, err := lockFile(ctx, afero.NewOsFs(), l.path, false, 0)
+		if err == nil {
+			l.shared = false
+			l.unlock = unlock
+			return true, nil
+		}
+		var lockedErr *LockedError
+		if !errors.As(err, &lockedErr) {
+			return false, fmt.Errorf(
+				"could not lock cached repository: %w",
+				err,
+			)
+		}
+	}
+	return false, l.lock(ctx, true)
+}
+
+// release releases the lock, if held.
+func (l *cacheLock) release() error {
+	if l.unlock == nil {
+		return nil
+	}
+	unlock := l.unlock
+	l.unlock = nil
+	return unlock()
+}
+
+// openCache opens the clone of the repository cached in cacheDir and resets
+// it to the remote branch, or clones the repository in cacheDir if it is not
+// cached yet. The cached clone stays locked until the backend is closed:
+// exclusively while it is updated, and shared with other readers otherwise.
+func (g *gitBackend) openCache(
+	ctx context.Context,
+	url, branch, cacheDir string,
+) error {
+	key := sha256.Sum256([]byte(url + "\n" + branch))
+	dir := filepath.Join(cacheDir, hex.EncodeToString(key[:8]))
+
+	// The lock file is next to the clone, which may be removed and cloned
+	// again. An offline clone is only read.
+	err := os.MkdirAll(cacheDir, 0o700)
+	if err != nil {
+		return err
+	}
+	l := &cacheLock{path: dir + ".lock"}
+	update, err := l.open(ctx, g.offline)
+	if err != nil {
+		return err
+	}
+	err = g.openCacheDir(ctx, url, branch, dir, !update)
+	if errors.Is(err, errNotCached) && !g.offline {
+		// The process updating the clone failed to clone the repository
+		err = l.lock(ctx, false)
+		if err == nil {
+			err = g.openCacheDir(ctx, url, branch, dir, false)
+		}
+	}
+	if err == nil {
+		err = l.lock(ctx, true)
+	}
+	if err != nil {
+		_ = l.release()
+		return err
+	}
+	g.cacheLock = l
 	return nil
 }
 
-func buildAuths(ctx context.Context, url string) ([]ssh.AuthMethod, error) {
-	logger := getLogger(ctx)
-	e, err := transport.NewEndpoint(url)
+var errNotCached = errors.New("repository is not cached")
+
+// openCacheDir opens or clones the cached repository in dir, which must be
+// locked. If readOnly is true, the cached repository is opened as is, and
+// errNotCached is returned if there is none.
+func (g *gitBackend) openCacheDir(
+	ctx context.Context,
+	url, branch, dir string,
+	readOnly bool,
+) error {
+	logger := getLogger(ctx).WithField("cache", dir)
+
+	fs := osfs.New(dir)
+	storer, err := newCacheStorer(fs)
 	if err != nil {
-		return nil, err
-	}
-	if e.Protocol != "ssh" {
-		return nil, nil
+		return err
 	}
 
-	logger.Info("configuring SSH authentication methods")
+	g.repo, err = git.Open(storer, fs)
+	if err == nil {
+		var shallow []plumbing.Hash
+		shallow, err = storer.Shallow()
+		if err != nil {
+			return err
+		}
+		if g.shallow || len(shallow) == 0 || readOnly {
+			logger.Info("using cached clone of git repository")
+			g.fs = fs
+			if readOnly {
+				return nil
+			}
+			return g.update(ctx, url, branch)
+		}
 
-	sshConfig := ssh_config.DefaultUserSettings
-	if sshConfig == nil {
-		defaultAuth, err := ssh.DefaultAuthBuilder(e.User)
+		// The full history is needed, but the cached clone is shallow
+		logger.Info("discarding shallow clone of git repository")
+		err = os.RemoveAll(dir)
+		if err == nil {
+			storer, err = newCacheStorer(fs)
+		}
 		if err != nil {
-			return nil, err
+			return err
 		}
-		logger.Info("SSH config not found, using default authentication")
-		return []ssh.AuthMethod{defaultAuth}, nil
+	} else if !errors.Is(err, git.ErrRepositoryNotExists) {
+		return err
 	}
 
-	auths := make([]ssh.AuthMethod, 0, 2)
+	if g.offline {
+		return fmt.Errorf("%w, cannot read offline", errNotCached)
+	}
+	if readOnly {
+		return errNotCached
+	}
 
-	identitiesOnly := sshConfig.Get(e.Host, "IdentitiesOnly")
-	if identitiesOnly != "yes" {
-		logger.Info("using SSH agent authentication (if available)")
-		sshAgentAuth, err := ssh.NewSSHAgentAuth(e.User)
+	logger.Info("caching git repository")
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return err
+	}
+	err = g.clone(ctx, url, branch, storer, fs)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		logger.Info("repository is empty")
+		// Start over from an empty directory
+		err = os.RemoveAll(dir)
+		if err == nil {
+			storer, err = newCacheStorer(fs)
+		}
 		if err == nil {
-			auths = append(auths, sshAgentAuth)
+			err = g.init(ctx, url, branch, storer, fs)
+		}
+	}
+	if err != nil {
+		// Do not leave a broken clone in the cache
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// newCacheStorer returns a storage for a repository in fs.
+func newCacheStorer(fs billy.Filesystem) (storage.Storer, error) {
+	dot, err := fs.Chroot(git.GitDirName)
+	if err != nil {
+		return nil, err
+	}
+	return filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), nil
+}
+
+// update fetches the remote and fast-forwards a cached clone to the remote
+// branch.
+func (g *gitBackend) update(ctx context.Context, url, branch string) error {
+	auths, err := g.buildAuths(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	if len(auths) > 0 {
+		for _, auth := range auths {
+			g.auth = auth
+			err = g.fetch(ctx)
+			if err == nil {
+				break
+			}
 		}
 	} else {
-		logger.Info("using identity files only")
+		err = g.fetch(ctx)
+	}
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	idFiles := sshConfig.GetAll(e.Host, "IdentityFile")
-	for _, idFile := range idFiles {
-		idFile, err := homedir.Expand(idFile)
-		if err != nil {
-			continue
+	return g.resetToRemote(ctx, branch)
+}
+
+// buildAuths returns the authentication methods to try, in order, to connect
+// to the remote at url. A nil slice means no authentication.
+func (g *gitBackend) buildAuths(
+	ctx context.Context,
+	url string,
+) ([]transport.AuthMethod, error) {
+	e, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Protocol {
+	case "ssh":
+		return g.buildSSHAuths(ctx, e)
+	case "http", "https":
+		return g.buildHTTPAuths(ctx, e)
+	default:
+		return nil, nil
+	}
+}
+
+func (g *gitBackend) buildSSHAuths(
+	ctx context.Context,
+	e *transport.Endpoint,
+) ([]transport.AuthMethod, error) {
+	hostKeyCallback, hostKeyAlgorithms, err := g.sshAuth.hostKeyCallback(
+		ctx,
+		e,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	auths, err := g.sshAuth.authMethods(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	setHostKeyCallback(auths, hostKeyCallback, hostKeyAlgorithms)
+	return auths, nil
+}
+
+func (g *gitBackend) buildHTTPAuths(
+	ctx context.Context,
+	e *transport.Endpoint,
+) ([]transport.AuthMethod, error) {
+	logger := getLogger(ctx)
+
+	if g.token != "" {
+		username := g.username
+		if username == "" {
+			username = e.User
 		}
-		logger := logger.WithField("identity_file", idFile)
-		publicKeyAuth, err := ssh.NewPublicKeysFromFile(e.User, idFile, "")
-		if err == nil {
-			logger.Info("identity file found")
-			auths = append(auths, publicKeyAuth)
-		} else {
-			logger.Info("identity file not found")
+		if username == "" {
+			// Most servers accept any username with an access token
+			username = defaultHTTPUsername
 		}
+		logger.
+			WithField("username", username).
+			Info("using configured HTTP credentials")
+		return []transport.AuthMethod{
+			&http.BasicAuth{Username: username, Password: g.token},
+		}, nil
 	}
 
-	if len(auths) > 0 {
-		return auths, nil
+	if e.Password != "" {
+		logger.Info("using HTTP credentials from URL")
+		return nil, nil
+	}
+
+	if g.username != "" {
+		e.User = g.username
+	}
+	auth, err := fillCredentials(ctx, e)
+	if err != nil {
+		logger.
+			WithError(err).
+			Info("no credentials from git credential helpers")
+		return nil, nil
+	}
+	logger.
+		WithField("username", auth.Username).
+		Info("using HTTP credentials from git credential helpers")
+	return []transport.AuthMethod{auth}, nil
+}
+
+const defaultHTTPUsername = "git"
+
+// fillCredentials gets the credentials for e from the user's git credential
+// helpers, using the `git credential fill` protocol. Terminal prompts are
+// disabled: fillCredentials returns an error if no helper has credentials for
+// e.
+func fillCredentials(
+	ctx context.Context,
+	e *transport.Endpoint,
+) (*http.BasicAuth, error) {
+	host := e.Host
+	if e.Port != 0 {
+		host = fmt.Sprintf("%s:%d", host, e.Port)
+	}
+	input := fmt.Sprintf(
+		"protocol=%s\nhost=%s\npath=%s\n",
+		e.Protocol,
+		host,
+		strings.TrimPrefix(e.Path, "/"),
+	)
+	if e.User != "" {
+		input += fmt.Sprintf("username=%s\n", e.User)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input + "\n")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git credential fill: %w", err)
 	}
 
-	return nil, fmt.Errorf("no valid authentication method")
+	auth := &http.BasicAuth{}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "username":
+			auth.Username = value
+		case "password":
+			auth.Password = value
+		}
+	}
+	if auth.Password == "" {
+		return nil, fmt.Errorf("no password returned by git credential fill")
+	}
+	return auth, nil
 }
 
+// checkout reads the files of the given revision in memory. The worktree is
+// left untouched, since it may be a cached clone shared with other processes.
 func (g *gitBackend) checkout(ctx context.Context, checkout string) error {
 	logger := getLogger(ctx)
 
@@ -471,14 +1633,255 @@ func (g *gitBackend) checkout(ctx context.Context, checkout string) error {
 	if err != nil {
 		return err
 	}
+	commit, err := g.repo.CommitObject(*hash)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("hash", hash).Info("reading files at commit hash")
+	fs := memfs.New()
+	err = tree.Files().ForEach(func(f *object.File) error {
+		contents, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		return util.WriteFile(fs, f.Name, []byte(contents), 0o600)
+	})
+	if err != nil {
+		return err
+	}
+	g.fs = fs
+	return nil
+}
+
+func (g gitBackend) commit(ctx context.Context, data []byte) error {
+	logger := getLogger(ctx)
+
+	logger = logger.WithField("path", g.path)
 
 	w, err := g.repo.Worktree()
 	if err != nil {
 		return err
 	}
 
-	logger.WithField("hash", hash).Info("checking out hash on a detached HEAD")
-	return w.Checkout(&git.CheckoutOptions{
-		Hash: *hash,
+	if g.localPath != "" && !g.noCommit {
+		err = g.checkStaged(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("opening file in git repository")
+	f, err := g.fs.OpenFile(g.path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o700)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	logger.Info("writing encrypted data to git repository")
+	n, err := f.Write(data)
+	if err != nil {
+		return err
+	}
+
+	if n != len(data) {
+		return fmt.Errorf("wrote %d bytes, expected %d", n, len(data))
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	logger.Info("staging file in worktree")
+	_, err = w.Add(g.path)
+	if err != nil {
+		return err
+	}
+	if g.noCommit {
+		return nil
+	}
+
+	return g.commitWorktree(ctx, w)
+}
+
+// commitWorktree commits the changes staged in w.
+func (g gitBackend) commitWorktree(
+	ctx context.Context,
+	w *git.Worktree,
+) error {
+	logger := getLogger(ctx).WithField("path", g.path)
+
+	authorCommitter, err := g.signature()
+	if err != nil {
+		return err
+	}
+	message := g.commitMessage(ctx)
+
+	logger.
+		WithField(
+			"committer",
+			fmt.Sprintf("%s <%s>", authorCommitter.Name, authorCommitter.Email),
+		).
+		Infof("committing changes to git repository: \"%s\"", message)
+	_, err = w.Commit(
+		message,
+		&git.CommitOptions{
+			Author:    authorCommitter,
+			Committer: authorCommitter,
+			Signer:    g.signer,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// commitKeys is a list of keys, formatted as a comma-separated list in commit
+// messages.
+type commitKeys []string
+
+func (k commitKeys) String() string {
+	return strings.Join(k, ", ")
+}
+
+// commitMessage executes the message template with the change carried by
+// ctx. If the message is not a valid template, it is returned as is.
+func (g gitBackend) commitMessage(ctx context.Context) string {
+	if g.template == nil {
+		return g.message
+	}
+
+	change, _ := ChangeFromContext(ctx)
+	data := struct {
+		Operation string
+		Keys      commitKeys
+	}{
+		Operation: change.Operation,
+		Keys:      commitKeys(change.Keys),
+	}
+
+	var buf strings.Builder
+	err := g.template.Execute(&buf, data)
+	if err != nil {
+		logger := getLogger(ctx)
+		logger.
+			WithError(err).
+			Warn("could not execute message template, using the message as is")
+		return g.message
+	}
+	return buf.String()
+}
+
+// signature returns the author and committer of new commits. The author name
+// and email that are not set in the options are read from the local, global
+// and system git configuration, in that order.
+func (g gitBackend) signature() (*object.Signature, error) {
+	sig := g.author
+	if sig.Name == "" || sig.Email == "" {
+		gitConfig, err := g.repo.ConfigScoped(config.SystemScope)
+		if err != nil {
+			return nil, err
+		}
+		if sig.Name == "" {
+			sig.Name = gitConfig.User.Name
+		}
+		if sig.Email == "" {
+			sig.Email = gitConfig.User.Email
+		}
+	}
+	sig.When = time.Now()
+	return &sig, nil
+}
+
+// checkStaged returns an error if files other than the store are staged in
+// the worktree, as they would be committed with the store.
+func (g gitBackend) checkStaged(w *git.Worktree) error {
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+	for path, fileStatus := range status {
+		if path == g.path {
+			continue
+		}
+		if fileStatus.Staging != git.Unmodified &&
+			fileStatus.Staging != git.Untracked {
+			return fmt.Errorf(
+				"\"%s\" is staged in the local repository, "+
+					"commit or unstage it first",
+				path,
+			)
+		}
+	}
+	return nil
+}
+
+func (g gitBackend) push(ctx context.Context) error {
+	logger := getLogger(ctx)
+
+	if g.localPath != "" {
+		return g.pushLocal(ctx)
+	}
+
+	logger.Info("pushing changes to git remote")
+	return g.repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: git.DefaultRemoteName,
+		Auth:       g.auth,
 	})
 }
+
+// pushLocal pushes the current branch of a local repository to its remote.
+func (g gitBackend) pushLocal(ctx context.Context) error {
+	logger := getLogger(ctx)
+
+	branch, err := g.currentBranch()
+	if err != nil {
+		return err
+	}
+	remoteName := git.DefaultRemoteName
+	cfg, err := g.repo.Config()
+	if err != nil {
+		return err
+	}
+	if b, ok := cfg.Branches[branch]; ok && b.Remote != "" {
+		remoteName = b.Remote
+	}
+	remote, ok := cfg.Remotes[remoteName]
+	if !ok || len(remote.URLs) == 0 {
+		return fmt.Errorf("no remote to push to: \"%s\"", remoteName)
+	}
+
+	// Authentication methods are only known once connected
+	auths, err := g.buildAuths(ctx, remote.URLs[0])
+	if err != nil {
+		return err
+	}
+	if len(auths) == 0 {
+		auths = []transport.AuthMethod{nil}
+	}
+
+	ref := plumbing.NewBranchReferenceName(branch)
+	logger.
+		WithField("remote", remoteName).
+		WithField("branch", branch).
+		Info("pushing changes to git remote")
+	for _, auth := range auths {
+		err = g.repo.PushContext(ctx, &git.PushOptions{
+			RemoteName: remoteName,
+			RefSpecs: []config.RefSpec{
+				config.RefSpec(fmt.Sprintf("%s:%s", ref, ref)),
+			},
+			Auth: auth,
+		})
+		if err == nil || isNonFastForward(err) {
+			break
+		}
+	}
+	return err
+}
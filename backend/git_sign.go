@@ -0,0 +1,183 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+// loadSigner reads the private key at path, and returns a signer for git
+// commits. The key is either an armored OpenPGP key, or an SSH private key.
+// passphrase is used to decrypt the key, if it is encrypted.
+func loadSigner(path, passphrase string) (git.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Contains(data, []byte("BEGIN PGP PRIVATE KEY BLOCK")) {
+		return newOpenPGPSigner(data, passphrase)
+	}
+	return newSSHSigner(data, passphrase)
+}
+
+type openpgpSigner struct {
+	entity *openpgp.Entity
+}
+
+func newOpenPGPSigner(data []byte, passphrase string) (git.Signer, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("no OpenPGP key found")
+	}
+	entity := entities[0]
+
+	if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+		if passphrase == "" {
+			return nil, fmt.Errorf(
+				"OpenPGP key is encrypted, missing passphrase",
+			)
+		}
+		err = entity.DecryptPrivateKeys([]byte(passphrase))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return openpgpSigner{entity: entity}, nil
+}
+
+func (s openpgpSigner) Sign(message io.Reader) ([]byte, error) {
+	var sig bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&sig, s.entity, message, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Bytes(), nil
+}
+
+// sshSigner signs git objects with the SSH signature format, as described in
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func newSSHSigner(data []byte, passphrase string) (git.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(data)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) {
+		if passphrase == "" {
+			return nil, fmt.Errorf("SSH key is encrypted, missing passphrase")
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(
+			data,
+			[]byte(passphrase),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sshSigner{signer: signer}, nil
+}
+
+const (
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "git"
+	sshSigHash      = "sha512"
+)
+
+func (s sshSigner) Sign(message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	_, err := io.Copy(h, message)
+	if err != nil {
+		return nil, err
+	}
+
+	signedData := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHash,
+		Hash:          h.Sum(nil),
+	})...)
+
+	var sig *ssh.Signature
+	algoSigner, ok := s.signer.(ssh.AlgorithmSigner)
+	if ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// SHA-1 RSA signatures are rejected by git
+		sig, err = algoSigner.SignWithAlgorithm(
+			rand.Reader,
+			signedData,
+			ssh.KeyAlgoRSASHA512,
+		)
+	} else {
+		sig, err = s.signer.Sign(rand.Reader, signedData)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{
+		Version:       1,
+		PublicKey:     s.signer.PublicKey().Marshal(),
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHash,
+		Signature:     ssh.Marshal(sig),
+	})...)
+
+	return armorSSHSignature(blob), nil
+}
+
+// armorSSHSignature encodes an SSH signature blob in the PEM-like format
+// produced by ssh-keygen.
+func armorSSHSignature(blob []byte) []byte {
+	const lineLength = 70
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var buf bytes.Buffer
+	buf.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > lineLength {
+		buf.WriteString(encoded[:lineLength])
+		buf.WriteByte('\n')
+		encoded = encoded[lineLength:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteString("\n-----END SSH SIGNATURE-----\n")
+	return buf.Bytes()
+}
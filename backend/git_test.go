@@ -0,0 +1,1548 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newBareRepo initializes an empty bare repository in a temporary directory
+// and returns its path.
+func newBareRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	out, err := exec.Command("git", "init", "--bare", "-b", "main", dir).
+		CombinedOutput()
+	if err != nil {
+		t.Fatalf("could not init bare repository: %s", out)
+	}
+	return dir
+}
+
+// newHTTPRepo serves an empty bare repository over smart HTTP with git
+// http-backend, requiring basic authentication with username and password.
+// Returns the URL of the repository.
+func newHTTPRepo(t *testing.T, username, password string) string {
+	t.Helper()
+	dir := newBareRepo(t)
+	out, err := exec.Command(
+		"git", "-C", dir, "config", "http.receivepack", "true",
+	).CombinedOutput()
+	if err != nil {
+		t.Fatalf("could not configure repository: %s", out)
+	}
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Fatalf("could not find git exec path: %s", err)
+	}
+
+	backend := &cgi.Handler{
+		Path: filepath.Join(
+			strings.TrimSpace(string(execPath)),
+			"git-http-backend",
+		),
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(dir),
+			"GIT_HTTP_EXPORT_ALL=1",
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			u, p, ok := r.BasicAuth()
+			if !ok || u != username || p != password {
+				w.Header().Set("WWW-Authenticate", `Basic realm="scrt"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			backend.ServeHTTP(w, r)
+		},
+	))
+	t.Cleanup(server.Close)
+
+	return server.URL + "/" + filepath.Base(dir)
+}
+
+// setGitConfig replaces the global git configuration with config for the
+// duration of the test.
+func setGitConfig(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gitconfig")
+	err := os.WriteFile(path, []byte(config), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", path)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+}
+
+// newSSHRepo serves an empty bare repository over SSH, accepting the client
+// key userKey. Returns the URL of the repository and the host key of the
+// server.
+func newSSHRepo(t *testing.T, userKey ssh.PublicKey) (string, ssh.PublicKey) {
+	t.Helper()
+	dir := newBareRepo(t)
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(
+			_ ssh.ConnMetadata,
+			key ssh.PublicKey,
+		) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), userKey.Marshal()) {
+				return nil, fmt.Errorf("unauthorized key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config, dir)
+		}
+	}()
+
+	url := fmt.Sprintf("ssh://git@%s/repo.git", l.Addr())
+	return url, hostSigner.PublicKey()
+}
+
+// serveSSH runs git-upload-pack and git-receive-pack on dir for the sessions
+// of an SSH connection.
+func serveSSH(conn net.Conn, config *ssh.ServerConfig, dir string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		channel, reqs, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer func() { _ = channel.Close() }()
+			for req := range reqs {
+				var payload struct{ Command string }
+				if req.Type != "exec" ||
+					ssh.Unmarshal(req.Payload, &payload) != nil {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+
+				service, _, _ := strings.Cut(payload.Command, " ")
+				cmd := exec.Command(
+					"git",
+					strings.TrimPrefix(service, "git-"),
+					dir,
+				)
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				stdin, err := cmd.StdinPipe()
+				if err != nil {
+					return
+				}
+				go func() {
+					_, _ = io.Copy(stdin, channel)
+					_ = stdin.Close()
+				}()
+				var status uint32
+				if cmd.Run() != nil {
+					status = 1
+				}
+				_, _ = channel.SendRequest(
+					"exit-status",
+					false,
+					ssh.Marshal(struct{ Status uint32 }{status}),
+				)
+				return
+			}
+		}()
+	}
+}
+
+// newSSHKey generates an SSH key pair, and writes the private key to a file,
+// encrypted with passphrase if it is not empty. Returns the path of the
+// private key and the public key.
+func newSSHKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(
+			priv,
+			"",
+			[]byte(passphrase),
+		)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	err = os.WriteFile(path, pem.EncodeToMemory(block), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, sshPub
+}
+
+// setSSHConfig replaces the SSH configuration with config for the duration of
+// the test.
+func setSSHConfig(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ssh_config")
+	err := os.WriteFile(path, []byte(config), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := &ssh_config.UserSettings{}
+	settings.ConfigFinder(func() string { return path })
+	defaultSettings := sshSettings
+	sshSettings = settings
+	t.Cleanup(func() { sshSettings = defaultSettings })
+}
+
+// writeKnownHosts writes a known hosts file with key for the host of url.
+func writeKnownHosts(t *testing.T, path, url string, key ssh.PublicKey) {
+	t.Helper()
+	host := strings.TrimPrefix(url, "ssh://git@")
+	host, _, _ = strings.Cut(host, "/")
+	line := knownhosts.Line([]string{knownhosts.Normalize(host)}, key)
+	err := os.WriteFile(path, []byte(line+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitSaveLoadRevision(t *testing.T) {
+	conf := map[string]interface{}{
+		"git-url":  newBareRepo(t),
+		"git-path": "store.scrt",
+	}
+
+	b, err := newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = b.(ConditionalSaver).LoadRevision()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	err = b.(ConditionalSaver).SaveRevision([]byte("v0"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b1, err := newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rev1, err := b1.(ConditionalSaver).LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rev2, err := b2.(ConditionalSaver).LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b1.(ConditionalSaver).SaveRevision([]byte("v1"), rev1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b2.(ConditionalSaver).SaveRevision([]byte("v2"), rev2)
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	// After a conflict, the backend reloads the remote changes
+	got, rev2, err := b2.(ConditionalSaver).LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v1"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v1"), got)
+	}
+	err = b2.(ConditionalSaver).SaveRevision([]byte("v2"), rev2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v2"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v2"), got)
+	}
+}
+
+func TestGitCache(t *testing.T) {
+	url := newBareRepo(t)
+	cacheDir := t.TempDir()
+	conf := map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+	}
+	cacheConf := map[string]interface{}{
+		"git-url":       url,
+		"git-path":      "store.scrt",
+		"git-cache":     true,
+		"git-cache-dir": cacheDir,
+	}
+	offlineConf := map[string]interface{}{
+		"git-url":       url,
+		"git-path":      "store.scrt",
+		"git-offline":   "true",
+		"git-cache-dir": cacheDir,
+	}
+
+	_, err := newGit(context.Background(), offlineConf)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Init the store in a cached clone
+	b, err := newGit(context.Background(), cacheConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeGit(t, b)
+
+	for i, data := range []string{"v1", "v2"} {
+		// Update the store from another clone
+		b, err = newGit(context.Background(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.Save([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err = newGit(context.Background(), cacheConf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]byte(data), got) {
+			t.Fatalf("%d: expected %#v, got %#v", i, []byte(data), got)
+		}
+		closeGit(t, b)
+	}
+
+	// Update the store without updating the cache
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = newGit(context.Background(), offlineConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v2"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v2"), got)
+	}
+	err = b.Save([]byte("v4"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	closeGit(t, b)
+}
+
+// closeGit closes b, releasing the lock on its cached clone.
+func closeGit(t *testing.T, b Backend) {
+	t.Helper()
+
+	err := b.(gitBackend).Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitCacheLock(t *testing.T) {
+	url := newBareRepo(t)
+	conf := map[string]interface{}{
+		"git-url":       url,
+		"git-path":      "store.scrt",
+		"git-cache":     true,
+		"git-cache-dir": t.TempDir(),
+	}
+
+	b, err := newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cached clone is locked exclusively until the backend is closed,
+	// once it was updated
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		2*lockRetryInterval,
+	)
+	defer cancel()
+	_, err = newGit(ctx, conf)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	closeGit(t, b)
+
+	// Concurrent readers share the lock
+	readers := make([]Backend, 2)
+	for i := range readers {
+		readers[i], err = newGit(context.Background(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range readers {
+		got, err := r.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]byte("v0"), got) {
+			t.Fatalf("expected %#v, got %#v", []byte("v0"), got)
+		}
+	}
+
+	// Updates wait for the readers
+	ctx, cancel = context.WithTimeout(
+		context.Background(),
+		2*lockRetryInterval,
+	)
+	defer cancel()
+	err = readers[0].SaveContext(ctx, []byte("v1"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	closeGit(t, readers[1])
+	err = readers[0].Save([]byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeGit(t, readers[0])
+}
+
+func TestGitCacheCheckout(t *testing.T) {
+	url := newBareRepo(t)
+	conf := map[string]interface{}{
+		"git-url":       url,
+		"git-path":      "store.scrt",
+		"git-cache":     true,
+		"git-cache-dir": t.TempDir(),
+	}
+
+	var revs []Revision
+	for _, data := range []string{"v0", "v1"} {
+		b, err := newGit(context.Background(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.Save([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rev, err := b.(ConditionalSaver).LoadRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, rev)
+		closeGit(t, b)
+	}
+
+	checkoutConf := map[string]interface{}{
+		"git-checkout": string(revs[0]),
+	}
+	for k, v := range conf {
+		checkoutConf[k] = v
+	}
+	b, err := newGit(context.Background(), checkoutConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v0"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v0"), got)
+	}
+	closeGit(t, b)
+
+	// Checking out a revision leaves the cached clone on the branch
+	conf["git-offline"] = true
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v1"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v1"), got)
+	}
+	closeGit(t, b)
+}
+
+func TestGitShallowClone(t *testing.T) {
+	url := newBareRepo(t)
+	cacheDir := t.TempDir()
+	conf := map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+	}
+
+	var revs []Revision
+	for _, data := range []string{"v0", "v1", "v2"} {
+		b, err := newGit(context.Background(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.Save([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rev, err := b.(ConditionalSaver).LoadRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, rev)
+	}
+
+	isShallow := func(b Backend) bool {
+		shallow, err := b.(gitBackend).repo.Storer.Shallow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(shallow) > 0
+	}
+
+	for _, cache := range []bool{false, true} {
+		conf := map[string]interface{}{
+			"git-url":       url,
+			"git-path":      "store.scrt",
+			"git-cache":     cache,
+			"git-cache-dir": cacheDir,
+		}
+		b, err := newGit(context.Background(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isShallow(b) {
+			t.Fatalf("cache=%t: expected shallow clone", cache)
+		}
+		got, err := b.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]byte("v2"), got) {
+			t.Fatalf("expected %#v, got %#v", []byte("v2"), got)
+		}
+		closeGit(t, b)
+
+		// A revision outside of the shallow history requires a full clone
+		conf["git-checkout"] = string(revs[0])
+		b, err = newGit(context.Background(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isShallow(b) {
+			t.Fatalf("cache=%t: expected full clone", cache)
+		}
+		got, err = b.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]byte("v0"), got) {
+			t.Fatalf("expected %#v, got %#v", []byte("v0"), got)
+		}
+		closeGit(t, b)
+	}
+}
+
+func TestGitHTTPAuth(t *testing.T) {
+	url := newHTTPRepo(t, "user", "secret")
+	setGitConfig(t, "")
+
+	_, err := newGit(context.Background(), map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	_, err = newGit(context.Background(), map[string]interface{}{
+		"git-url":      url,
+		"git-path":     "store.scrt",
+		"git-username": "user",
+		"git-token":    "wrong",
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	b, err := newGit(context.Background(), map[string]interface{}{
+		"git-url":      url,
+		"git-path":     "store.scrt",
+		"git-username": "user",
+		"git-token":    "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Credentials from a git credential helper
+	setGitConfig(
+		t,
+		"[credential]\n"+
+			"\thelper = \"!f() { test $1 = get && "+
+			"echo username=user && echo password=secret; }; f\"\n",
+	)
+	b, err = newGit(context.Background(), map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v0"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v0"), got)
+	}
+	err = b.Save([]byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// headCommit returns the commit at HEAD in the clone of b.
+func headCommit(t *testing.T, b Backend) *object.Commit {
+	t.Helper()
+	repo := b.(gitBackend).repo
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
+
+func TestGitCommitAuthor(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	err := os.WriteFile(
+		filepath.Join(home, ".gitconfig"),
+		[]byte("[user]\n\tname = Global User\n\temail = global@example.com\n"),
+		0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := newBareRepo(t)
+	testCases := []struct {
+		conf  map[string]interface{}
+		name  string
+		email string
+	}{
+		{
+			conf:  map[string]interface{}{},
+			name:  "Global User",
+			email: "global@example.com",
+		},
+		{
+			conf:  map[string]interface{}{"git-author-name": "Alice"},
+			name:  "Alice",
+			email: "global@example.com",
+		},
+		{
+			conf: map[string]interface{}{
+				"git-author-name":  "Alice",
+				"git-author-email": "alice@example.com",
+			},
+			name:  "Alice",
+			email: "alice@example.com",
+		},
+	}
+
+	for i, tc := range testCases {
+		tc.conf["git-url"] = url
+		tc.conf["git-path"] = "store.scrt"
+		b, err := newGit(context.Background(), tc.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.Save([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit := headCommit(t, b)
+		sigs := []object.Signature{commit.Author, commit.Committer}
+		for _, sig := range sigs {
+			if sig.Name != tc.name || sig.Email != tc.email {
+				t.Errorf(
+					"%d: expected %s <%s>, got %s <%s>",
+					i,
+					tc.name,
+					tc.email,
+					sig.Name,
+					sig.Email,
+				)
+			}
+		}
+	}
+}
+
+func TestGitCommitMessage(t *testing.T) {
+	url := newBareRepo(t)
+	change := Change{Operation: "set", Keys: []string{"hello", "world"}}
+	testCases := []struct {
+		message string
+		ctx     context.Context
+		want    string
+	}{
+		{
+			message: "",
+			ctx:     NewChangeContext(context.Background(), change),
+			want:    defaultCommitMessage,
+		},
+		{
+			message: "scrt: {{.Operation}} {{.Keys}}",
+			ctx:     NewChangeContext(context.Background(), change),
+			want:    "scrt: set hello, world",
+		},
+		{
+			message: "{{range .Keys}}{{.}};{{end}}",
+			ctx:     NewChangeContext(context.Background(), change),
+			want:    "hello;world;",
+		},
+		{
+			message: "scrt: {{.Operation}} {{.Keys}}",
+			ctx:     context.Background(),
+			want:    "scrt:  ",
+		},
+		// Messages that are not valid templates are used as is
+		{
+			message: "update {{secrets",
+			ctx:     NewChangeContext(context.Background(), change),
+			want:    "update {{secrets",
+		},
+		{
+			message: "update {{.Secrets}}",
+			ctx:     NewChangeContext(context.Background(), change),
+			want:    "update {{.Secrets}}",
+		},
+	}
+
+	for i, tc := range testCases {
+		b, err := newGit(context.Background(), map[string]interface{}{
+			"git-url":     url,
+			"git-path":    "store.scrt",
+			"git-message": tc.message,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.SaveContext(tc.ctx, []byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit := headCommit(t, b)
+		if commit.Message != tc.want {
+			t.Errorf("%d: expected %q, got %q", i, tc.want, commit.Message)
+		}
+	}
+}
+
+func TestGitSignOpenPGP(t *testing.T) {
+	entity, err := openpgp.NewEntity("scrt", "", "scrt@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var privateKey, publicKey bytes.Buffer
+	w, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = entity.SerializePrivate(w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+	w, err = armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = entity.Serialize(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	keyPath := filepath.Join(t.TempDir(), "key.asc")
+	err = os.WriteFile(keyPath, privateKey.Bytes(), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newGit(context.Background(), map[string]interface{}{
+		"git-url":         newBareRepo(t),
+		"git-path":        "store.scrt",
+		"git-signing-key": keyPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = headCommit(t, b).Verify(publicKey.String())
+	if err != nil {
+		t.Fatalf("could not verify commit signature: %s", err)
+	}
+}
+
+func TestGitSignSSH(t *testing.T) {
+	keyPath, sshPub := newSSHKey(t, "passphrase")
+	allowedSigners := filepath.Join(t.TempDir(), "allowed_signers")
+	err := os.WriteFile(
+		allowedSigners,
+		[]byte("scrt@example.com "+string(ssh.MarshalAuthorizedKey(sshPub))),
+		0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := newBareRepo(t)
+	conf := map[string]interface{}{
+		"git-url":          url,
+		"git-path":         "store.scrt",
+		"git-author-email": "scrt@example.com",
+		"git-signing-key":  keyPath,
+	}
+	_, err = newGit(context.Background(), conf)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	conf["git-signing-key-passphrase"] = "passphrase"
+	b, err := newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(
+		"git",
+		"-C", url,
+		"-c", "gpg.format=ssh",
+		"-c", "gpg.ssh.allowedSignersFile="+allowedSigners,
+		"verify-commit", "main",
+	).CombinedOutput()
+	if err != nil {
+		t.Fatalf("could not verify commit signature: %s", out)
+	}
+}
+
+func TestGitSSHHostKey(t *testing.T) {
+	keyPath, userKey := newSSHKey(t, "")
+	url, hostKey := newSSHRepo(t, userKey)
+	_, otherKey := newSSHKey(t, "")
+	dir := t.TempDir()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	conf := map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+	}
+	sshConfig := func(strict string) string {
+		return fmt.Sprintf(
+			"Host *\n"+
+				"  IdentitiesOnly yes\n"+
+				"  IdentityFile %s\n"+
+				"  UserKnownHostsFile %s\n"+
+				"  StrictHostKeyChecking %s\n",
+			keyPath,
+			knownHosts,
+			strict,
+		)
+	}
+
+	// Unknown host
+	setSSHConfig(t, sshConfig("yes"))
+	_, err := newGit(context.Background(), conf)
+	if err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("expected unknown host error, got %v", err)
+	}
+
+	// Unknown host is added to known hosts
+	setSSHConfig(t, sshConfig("accept-new"))
+	b, err := newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Known host
+	setSSHConfig(t, sshConfig("yes"))
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("data"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("data"), got)
+	}
+
+	// Spoofed host
+	writeKnownHosts(t, knownHosts, url, otherKey)
+	for _, strict := range []string{"yes", "accept-new"} {
+		setSSHConfig(t, sshConfig(strict))
+		_, err = newGit(context.Background(), conf)
+		if err == nil || !strings.Contains(err.Error(), "changed") {
+			t.Fatalf("%s: expected changed host key error, got %v", strict, err)
+		}
+	}
+	setSSHConfig(t, sshConfig("no"))
+	_, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Known hosts from the options
+	setSSHConfig(t, sshConfig("yes"))
+	optKnownHosts := filepath.Join(dir, "known_hosts_opt")
+	writeKnownHosts(t, optKnownHosts, url, hostKey)
+	conf["git-known-hosts"] = optKnownHosts
+	_, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKnownHosts(t, optKnownHosts, url, otherKey)
+	_, err = newGit(context.Background(), conf)
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Fatalf("expected changed host key error, got %v", err)
+	}
+}
+
+func TestGitSSHKeyPassphrase(t *testing.T) {
+	keyPath, userKey := newSSHKey(t, "passphrase")
+	url, _ := newSSHRepo(t, userKey)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	sshConfig := fmt.Sprintf(
+		"Host *\n"+
+			"  IdentitiesOnly yes\n"+
+			"  UserKnownHostsFile %s\n"+
+			"  StrictHostKeyChecking accept-new\n",
+		knownHosts,
+	)
+	setSSHConfig(t, sshConfig)
+
+	prompts := 0
+	defaultReadPassphrase := readPassphrase
+	readPassphrase = func(string) ([]byte, error) {
+		prompts++
+		return []byte("passphrase"), nil
+	}
+	t.Cleanup(func() { readPassphrase = defaultReadPassphrase })
+
+	// Passphrase from the terminal, once
+	b, err := newGit(context.Background(), map[string]interface{}{
+		"git-url":     url,
+		"git-path":    "store.scrt",
+		"git-ssh-key": keyPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompts != 1 {
+		t.Fatalf("expected 1 passphrase prompt, got %d", prompts)
+	}
+
+	// Passphrase from the options
+	b, err = newGit(context.Background(), map[string]interface{}{
+		"git-url":                url,
+		"git-path":               "store.scrt",
+		"git-ssh-key":            keyPath,
+		"git-ssh-key-passphrase": "passphrase",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompts != 1 {
+		t.Fatalf("expected no passphrase prompt, got %d", prompts-1)
+	}
+
+	_, err = newGit(context.Background(), map[string]interface{}{
+		"git-url":                url,
+		"git-path":               "store.scrt",
+		"git-ssh-key":            keyPath,
+		"git-ssh-key-passphrase": "wrong",
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Identity file from the SSH config
+	setSSHConfig(t, sshConfig+"  IdentityFile "+keyPath+"\n")
+	b, err = newGit(context.Background(), map[string]interface{}{
+		"git-url":                url,
+		"git-path":               "store.scrt",
+		"git-ssh-key-passphrase": "passphrase",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("data"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("data"), got)
+	}
+}
+
+// runGit runs a git command in dir, and returns its output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).
+		CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s", strings.Join(args, " "), out)
+	}
+	return string(out)
+}
+
+func TestGitLocalPath(t *testing.T) {
+	url := newBareRepo(t)
+	b, err := newGit(context.Background(), map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteHead := runGit(t, url, "rev-parse", "main")
+
+	dir := filepath.Join(t.TempDir(), "repo")
+	runGit(t, ".", "clone", "-q", url, dir)
+
+	_, err = newGit(context.Background(), map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+		"git-push": false,
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Stage only
+	conf := map[string]interface{}{
+		"git-local-path": dir,
+		"git-path":       "store.scrt",
+		"git-commit":     "false",
+	}
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v0"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v0"), got)
+	}
+	err = b.Save([]byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := runGit(t, dir, "status", "--porcelain")
+	if status != "M  store.scrt\n" {
+		t.Fatalf("expected store to be staged, got %q", status)
+	}
+
+	// Commit without pushing
+	conf["git-commit"] = true
+	conf["git-push"] = false
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = runGit(t, dir, "status", "--porcelain")
+	if status != "" {
+		t.Fatalf("expected clean working copy, got %q", status)
+	}
+	if runGit(t, dir, "rev-parse", "HEAD~1") != remoteHead {
+		t.Fatal("expected a new local commit")
+	}
+	if runGit(t, url, "rev-parse", "main") != remoteHead {
+		t.Fatal("expected remote not to be updated")
+	}
+
+	// Other staged files are not committed
+	err = os.WriteFile(filepath.Join(dir, "other"), []byte("other"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "other")
+	err = b.Save([]byte("v3"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	runGit(t, dir, "reset", "-q", "other")
+
+	// Commit and push
+	conf["git-push"] = true
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	localHead := runGit(t, dir, "rev-parse", "HEAD")
+	if runGit(t, url, "rev-parse", "main") != localHead {
+		t.Fatal("expected remote to be updated")
+	}
+	got = []byte(runGit(t, url, "show", "main:store.scrt"))
+	if !reflect.DeepEqual([]byte("v3"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v3"), got)
+	}
+}
+
+func TestGitLocalPathDetached(t *testing.T) {
+	url := newBareRepo(t)
+	b, err := newGit(context.Background(), map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "repo")
+	runGit(t, ".", "clone", "-q", url, dir)
+	runGit(t, dir, "checkout", "-q", "--detach", "HEAD")
+
+	_, err = newGit(context.Background(), map[string]interface{}{
+		"git-local-path": dir,
+		"git-path":       "store.scrt",
+	})
+	if !errors.Is(err, errDetachedHead) {
+		t.Fatalf("expected detached HEAD error, got %v", err)
+	}
+}
+
+func TestGitNestedOptions(t *testing.T) {
+	url := newBareRepo(t)
+	dir := filepath.Join(t.TempDir(), "repo")
+	runGit(t, ".", "clone", "-q", url, dir)
+
+	conf := readConf(
+		t,
+		gitFactory{},
+		"git:\n  local-path: "+dir+"\n  path: store.scrt\n"+
+			"  commit: false\n  push: false\n",
+	)
+	b, err := newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := b.(gitBackend)
+	if !g.noCommit || !g.noPush {
+		t.Fatalf(
+			"expected no commit and no push, got commit=%t push=%t",
+			!g.noCommit,
+			!g.noPush,
+		)
+	}
+
+	cacheDir := t.TempDir()
+	conf = readConf(
+		t,
+		gitFactory{},
+		"git:\n  url: "+url+"\n  path: store.scrt\n"+
+			"  cache: true\n  cache-dir: "+cacheDir+"\n",
+	)
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.(gitBackend).cacheLock == nil {
+		t.Fatal("expected cached clone")
+	}
+	closeGit(t, b)
+
+	conf = readConf(
+		t,
+		gitFactory{},
+		"git:\n  url: "+url+"\n  path: store.scrt\n"+
+			"  offline: true\n  cache-dir: "+cacheDir+"\n",
+	)
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.(gitBackend).offline {
+		t.Fatal("expected offline clone")
+	}
+	closeGit(t, b)
+}
+
+func TestGitLoadVersion(t *testing.T) {
+	url := newBareRepo(t)
+	dir := filepath.Join(t.TempDir(), "repo")
+	runGit(t, ".", "clone", "-q", url, dir)
+
+	dates := []string{
+		"2024-01-01T00:00:00Z",
+		"2024-03-01T00:00:00Z",
+		"2024-05-01T00:00:00Z",
+	}
+	for i, date := range dates {
+		data := []byte(fmt.Sprintf("v%d", i))
+		err := os.WriteFile(filepath.Join(dir, "store.scrt"), data, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("GIT_COMMITTER_DATE", date)
+		runGit(t, dir, "add", "store.scrt")
+		runGit(
+			t,
+			dir,
+			"-c", "user.name=scrt",
+			"-c", "user.email=scrt@example.com",
+			"commit", "-q", "-m", date,
+		)
+	}
+	runGit(t, dir, "push", "-q", "origin", "main")
+
+	b, err := newGit(context.Background(), map[string]interface{}{
+		"git-url":  url,
+		"git-path": "store.scrt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := b.(Versioned)
+
+	// History is fetched beyond the shallow clone
+	versions, err := v.ListVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != len(dates) {
+		t.Fatalf("expected %d versions, got %d", len(dates), len(versions))
+	}
+	for i, version := range versions {
+		want, _ := time.Parse(time.RFC3339, dates[len(dates)-1-i])
+		if !version.ModTime.Equal(want) {
+			t.Errorf("%d: expected %s, got %s", i, want, version.ModTime)
+		}
+	}
+	got, err := v.LoadVersion(versions[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v1"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v1"), got)
+	}
+
+	got, err = v.LoadVersion("HEAD~2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v0"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v0"), got)
+	}
+
+	got, err = v.LoadAt(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v1"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v1"), got)
+	}
+
+	_, err = v.LoadAt(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	_, err = v.LoadVersion("unknown")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// A checked out revision is read-only
+	b, err = newGit(context.Background(), map[string]interface{}{
+		"git-url":      url,
+		"git-path":     "store.scrt",
+		"git-checkout": "HEAD~1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("v1"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("v1"), got)
+	}
+	err = b.Save([]byte("v3"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	err = b.(ConditionalSaver).SaveRevision([]byte("v3"), "")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGitListStores(t *testing.T) {
+	url := newBareRepo(t)
+	for _, path := range []string{
+		"teams/a/dev.scrt",
+		"teams/a/prod.scrt",
+		"teams/b/dev.scrt",
+		"other.scrt",
+	} {
+		b, err := newGit(context.Background(), map[string]interface{}{
+			"git-url":  url,
+			"git-path": path,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.Save([]byte("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := newGit(context.Background(), map[string]interface{}{
+		"git-url":  url,
+		"git-path": "teams",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.(Lister).ListStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"teams/a/dev.scrt",
+		"teams/a/prod.scrt",
+		"teams/b/dev.scrt",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+
+	b, err = newGit(context.Background(), map[string]interface{}{
+		"git-url":  url,
+		"git-path": "teams/a/new.scrt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = b.(Lister).ListStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"teams/a/dev.scrt", "teams/a/prod.scrt"}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+}
+
+func TestGitDelete(t *testing.T) {
+	url := newBareRepo(t)
+	conf := map[string]interface{}{
+		"git-url":     url,
+		"git-path":    "store.scrt",
+		"git-message": "scrt: {{.Operation}}",
+	}
+
+	b, err := newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewChangeContext(
+		context.Background(),
+		Change{Operation: "destroy"},
+	)
+	err = b.(Deleter).DeleteContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := headCommit(t, b)
+	if commit.Message != "scrt: destroy" {
+		t.Fatalf("expected %q, got %q", "scrt: destroy", commit.Message)
+	}
+
+	// Check the removal was pushed
+	b, err = newGit(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected store not to exist")
+	}
+
+	err = b.(Deleter).Delete()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
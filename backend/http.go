@@ -0,0 +1,433 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/pflag"
+)
+
+var httpFlagSet *pflag.FlagSet
+
+func init() {
+	httpFlagSet = pflag.NewFlagSet("http", pflag.ContinueOnError)
+	httpFlagSet.String("http-url", "", "URL of the store (required)")
+	httpFlagSet.String(
+		"http-username",
+		"",
+		"username for basic authentication",
+	)
+	httpFlagSet.String(
+		"http-password",
+		"",
+		"password for basic authentication",
+	)
+	httpFlagSet.String("http-token", "", "token for bearer authentication")
+	httpFlagSet.String(
+		"http-client-cert",
+		"",
+		"path to a PEM client certificate for TLS authentication",
+	)
+	httpFlagSet.String(
+		"http-client-key",
+		"",
+		"path to the PEM private key of the client certificate",
+	)
+	httpFlagSet.String(
+		"http-ca-cert",
+		"",
+		"path to PEM CA certificates to verify the server (default system CAs)",
+	)
+	markSecret(httpFlagSet, "http-password", "http-token")
+}
+
+// httpBackend stores the store at a URL, e.g. on a WebDAV server: GET to
+// load, PUT to save, HEAD to check existence and DELETE to delete. Conditional
+// saves use the ETag of the store.
+type httpBackend struct {
+	url      *url.URL
+	client   *http.Client
+	username string
+	password string
+	token    string
+}
+
+type httpFactory struct{}
+
+func (f httpFactory) New(conf map[string]interface{}) (Backend, error) {
+	return f.NewContext(context.Background(), conf)
+}
+
+func (f httpFactory) NewContext(
+	ctx context.Context,
+	conf map[string]interface{},
+) (Backend, error) {
+	return newHTTP(ctx, conf)
+}
+
+func (f httpFactory) Name() string {
+	return "HTTP"
+}
+
+func (f httpFactory) Description() string {
+	return "store in a file on an HTTP or WebDAV server"
+}
+
+func (f httpFactory) Flags() *pflag.FlagSet {
+	return httpFlagSet
+}
+
+func (f httpFactory) Capabilities() []Capability {
+	return Capabilities(httpBackend{})
+}
+
+func init() {
+	Backends["http"] = httpFactory{}
+}
+
+func newHTTP(
+	ctx context.Context,
+	conf map[string]interface{},
+) (Backend, error) {
+	logger := getLogger(ctx)
+
+	var rawURL, certFile, keyFile, caFile string
+	b := httpBackend{}
+	opts := []struct {
+		name  string
+		label string
+		dest  *string
+	}{
+		{"url", "URL", &rawURL},
+		{"username", "username", &b.username},
+		{"password", "password", &b.password},
+		{"token", "token", &b.token},
+		{"client-cert", "client certificate", &certFile},
+		{"client-key", "client key", &keyFile},
+		{"ca-cert", "CA certificate", &caFile},
+	}
+	for _, o := range opts {
+		opt := readOpt("http", o.name, conf)
+		if opt == nil || opt == "" {
+			continue
+		}
+		s, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a string: (%T)", o.label, opt)
+		}
+		*o.dest = s
+	}
+
+	if rawURL == "" {
+		return nil, fmt.Errorf("missing URL")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid URL scheme: %s", u.Scheme)
+	}
+	b.url = u
+
+	if b.password != "" && b.username == "" {
+		return nil, fmt.Errorf("HTTP password requires a username")
+	}
+	if b.token != "" && b.username != "" {
+		return nil, fmt.Errorf(
+			"HTTP token cannot be used with basic authentication",
+		)
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf(
+			"HTTP client certificate and key must be set together",
+		)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		certFile, err = homedir.Expand(certFile)
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err = homedir.Expand(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		caFile, err = homedir.Expand(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	b.client = &http.Client{Transport: transport}
+
+	logger.
+		WithField("host", u.Host).
+		WithField("path", u.Path).
+		Info("using HTTP storage")
+
+	return b, nil
+}
+
+// do sends a request to the URL of the store, with the authentication of the
+// backend.
+func (b httpBackend) do(
+	ctx context.Context,
+	method string,
+	header http.Header,
+	body []byte,
+) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.url.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	switch {
+	case b.token != "":
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	case b.username != "":
+		req.SetBasicAuth(b.username, b.password)
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		// Strip the URL from the error, as it may contain credentials
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// httpStatusError returns the error for an unsuccessful response.
+func httpStatusError(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("access denied: %s", res.Status)
+	default:
+		return fmt.Errorf("unexpected response: %s", res.Status)
+	}
+}
+
+// httpSuccess returns true if the response status is 2xx.
+func httpSuccess(res *http.Response) bool {
+	return res.StatusCode >= 200 && res.StatusCode <= 299
+}
+
+func (b httpBackend) Exists() (bool, error) {
+	return b.ExistsContext(context.Background())
+}
+
+func (b httpBackend) ExistsContext(ctx context.Context) (bool, error) {
+	logger := getLogger(ctx)
+	logger.WithField("host", b.url.Host).Info("checking store existence")
+
+	res, err := b.do(ctx, http.MethodHead, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	case !httpSuccess(res):
+		return false, httpStatusError(res)
+	}
+	return true, nil
+}
+
+func (b httpBackend) Save(data []byte) error {
+	return b.SaveContext(context.Background(), data)
+}
+
+func (b httpBackend) SaveContext(ctx context.Context, data []byte) error {
+	return b.put(ctx, data, nil)
+}
+
+func (b httpBackend) Load() ([]byte, error) {
+	return b.LoadContext(context.Background())
+}
+
+func (b httpBackend) LoadContext(ctx context.Context) ([]byte, error) {
+	data, _, err := b.get(ctx)
+	return data, err
+}
+
+func (b httpBackend) LoadRevision() ([]byte, Revision, error) {
+	return b.LoadRevisionContext(context.Background())
+}
+
+// httpNoETag is the revision of a store read from a server that did not
+// return a strong ETag. ETags are quoted, so it never matches one.
+const httpNoETag Revision = "no-etag"
+
+// LoadRevisionContext reads the store and returns its ETag as the revision.
+// If the server does not return a strong ETag, the store will be saved
+// without checking for conflicting updates.
+func (b httpBackend) LoadRevisionContext(
+	ctx context.Context,
+) ([]byte, Revision, error) {
+	logger := getLogger(ctx)
+
+	data, etag, err := b.get(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		logger.Warn(
+			"server did not return a strong ETag, " +
+				"conflicting updates will not be detected",
+		)
+		return data, httpNoETag, nil
+	}
+	return data, Revision(etag), nil
+}
+
+func (b httpBackend) SaveRevision(data []byte, rev Revision) error {
+	return b.SaveRevisionContext(context.Background(), data, rev)
+}
+
+func (b httpBackend) SaveRevisionContext(
+	ctx context.Context,
+	data []byte,
+	rev Revision,
+) error {
+	if rev == httpNoETag {
+		return b.put(ctx, data, nil)
+	}
+
+	header := http.Header{}
+	if rev == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", string(rev))
+	}
+	return b.put(ctx, data, header)
+}
+
+func (b httpBackend) Delete() error {
+	return b.DeleteContext(context.Background())
+}
+
+func (b httpBackend) DeleteContext(ctx context.Context) error {
+	logger := getLogger(ctx)
+	logger.WithField("host", b.url.Host).Info("deleting store")
+
+	res, err := b.do(ctx, http.MethodDelete, nil, nil)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return &NotFoundError{}
+	case !httpSuccess(res):
+		return httpStatusError(res)
+	}
+	return nil
+}
+
+// get reads the store, and returns its data and ETag.
+func (b httpBackend) get(ctx context.Context) ([]byte, string, error) {
+	logger := getLogger(ctx)
+	logger.
+		WithField("host", b.url.Host).
+		Info("reading encrypted data from HTTP storage")
+
+	res, err := b.do(ctx, http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, "", &NotFoundError{}
+	case !httpSuccess(res):
+		return nil, "", httpStatusError(res)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, res.Header.Get("ETag"), nil
+}
+
+// put writes the store, with the preconditions in header.
+func (b httpBackend) put(
+	ctx context.Context,
+	data []byte,
+	header http.Header,
+) error {
+	logger := getLogger(ctx)
+	logger.
+		WithField("host", b.url.Host).
+		WithField("if_match", header.Get("If-Match")).
+		Info("writing encrypted data to HTTP storage")
+
+	res, err := b.do(ctx, http.MethodPut, header, data)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusPreconditionFailed:
+		return &ConflictError{Err: errors.New(res.Status)}
+	case !httpSuccess(res):
+		return httpStatusError(res)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+)
+
+func TestHTTPFactory(t *testing.T) {
+	f := httpFactory{}
+
+	testGenericFactory(t, f)
+
+	_, err := f.New(map[string]interface{}{
+		"http": map[string]interface{}{
+			"url": "https://dav.example.com/scrt/store.scrt",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	testCases := []map[string]interface{}{
+		{},
+		{"http-url": 12},
+		{"http-url": "ftp://example.com/store.scrt"},
+		{"http-url": "https://example.com/%zz"},
+		{
+			"http-url":      "https://example.com/store.scrt",
+			"http-password": "p4ssw0rd",
+		},
+		{
+			"http-url":      "https://example.com/store.scrt",
+			"http-username": "user",
+			"http-token":    "t0k3n",
+		},
+		{
+			"http-url":         "https://example.com/store.scrt",
+			"http-client-cert": "client.crt",
+		},
+		{
+			"http-url":         "https://example.com/store.scrt",
+			"http-client-cert": "/does/not/exist.crt",
+			"http-client-key":  "/does/not/exist.key",
+		},
+		{
+			"http-url":     "https://example.com/store.scrt",
+			"http-ca-cert": "/does/not/exist.crt",
+		},
+	}
+	for i, conf := range testCases {
+		_, err := f.New(conf)
+		if err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
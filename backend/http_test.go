@@ -0,0 +1,390 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// httpStore is an in-memory HTTP server storing a single file, with ETags.
+type httpStore struct {
+	mu   sync.Mutex
+	data []byte
+	etag string
+	n    int
+	// auth returns false if the request is not authorized
+	auth func(r *http.Request) bool
+	// weak makes the server return weak ETags
+	weak bool
+}
+
+func (s *httpStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.auth != nil && !s.auth(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if s.etag == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", s.etag)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(s.data)
+		}
+	case http.MethodPut:
+		if m := r.Header.Get("If-Match"); m != "" && m != s.etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && s.etag != "" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.data = data
+		s.n++
+		s.etag = fmt.Sprintf(`"%d"`, s.n)
+		if s.weak {
+			s.etag = "W/" + s.etag
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if s.etag == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.data = nil
+		s.etag = ""
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHTTPSaveLoad(t *testing.T) {
+	server := httptest.NewServer(&httpStore{})
+	t.Cleanup(server.Close)
+
+	b, err := newHTTP(
+		context.Background(),
+		map[string]interface{}{"http-url": server.URL + "/store.scrt"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected store not to exist")
+	}
+	_, err = b.Load()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	for _, data := range []string{"v0", "v1"} {
+		err = b.Save([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]byte(data), got) {
+			t.Fatalf("expected %#v, got %#v", []byte(data), got)
+		}
+	}
+
+	exists, err = b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected store to exist")
+	}
+
+	err = b.(Deleter).Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.(Deleter).Delete()
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestHTTPSaveLoadRevision(t *testing.T) {
+	store := &httpStore{}
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+
+	b, err := newHTTP(
+		context.Background(),
+		map[string]interface{}{"http-url": server.URL + "/store.scrt"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := b.(ConditionalSaver)
+
+	err = cs.SaveRevision([]byte("v0"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.SaveRevision([]byte("v0"), "")
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	_, rev, err := cs.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.SaveRevision([]byte("v2"), rev)
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	_, rev, err = cs.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.SaveRevision([]byte("v2"), rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Weak ETags are not used for conditional saves
+	store.weak = true
+	err = b.Save([]byte("v3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, rev, err = cs.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.SaveRevision([]byte("v4"), rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "v4" {
+		t.Fatalf("expected %q, got %q", "v4", got)
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	store := &httpStore{}
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+	url := server.URL + "/store.scrt"
+
+	store.auth = func(r *http.Request) bool {
+		username, password, ok := r.BasicAuth()
+		return ok && username == "user" && password == "p4ssw0rd"
+	}
+	b, err := newHTTP(context.Background(), map[string]interface{}{
+		"http-url":      url,
+		"http-username": "user",
+		"http-password": "p4ssw0rd",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.auth = func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer t0k3n"
+	}
+	b, err = newHTTP(context.Background(), map[string]interface{}{
+		"http-url":   url,
+		"http-token": "t0k3n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = newHTTP(context.Background(), map[string]interface{}{
+		"http-url": url,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Load()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var notFoundErr *NotFoundError
+	if errors.As(err, &notFoundErr) {
+		t.Fatalf("expected access denied error, got %v", err)
+	}
+}
+
+// writeCert writes a new self-signed certificate and its key to dir, and
+// returns their paths.
+func writeCert(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader,
+		template,
+		template,
+		key.Public(),
+		key,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	err = os.WriteFile(
+		certPath,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(
+		keyPath,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestHTTPClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCert(t, dir, "client")
+	clientPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientPEM)
+
+	server := httptest.NewUnstartedServer(&httpStore{})
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caPath := filepath.Join(dir, "ca.crt")
+	err = os.WriteFile(
+		caPath,
+		pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: server.Certificate().Raw,
+		}),
+		0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := map[string]interface{}{
+		"http-url":     server.URL + "/store.scrt",
+		"http-ca-cert": caPath,
+	}
+	b, err := newHTTP(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Exists()
+	if err == nil {
+		t.Fatal("expected error without client certificate")
+	}
+
+	conf["http-client-cert"] = certPath
+	conf["http-client-key"] = keyPath
+	b, err = newHTTP(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -16,9 +16,15 @@ package backend
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/afero"
@@ -34,11 +40,46 @@ func init() {
 		"",
 		"path to the store in the local filesystem (required)",
 	)
+	localFlagSet.Duration(
+		"local-lock-timeout",
+		defaultLockTimeout,
+		"how long to wait for another process to release the store",
+	)
+	localFlagSet.Int(
+		"local-backups",
+		0,
+		"number of previous versions of the store to keep as backups",
+	)
+}
+
+const (
+	defaultLockTimeout = 10 * time.Second
+	lockRetryInterval  = 100 * time.Millisecond
+)
+
+var (
+	errLocked          = errors.New("file is locked")
+	errLockUnsupported = errors.New("file locking is not supported")
+)
+
+// LockedError is returned when a store is locked by another process.
+type LockedError struct {
+	// PID is the process ID of the process holding the lock, or 0 if unknown
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return "store is locked by another process"
+	}
+	return fmt.Sprintf("store is locked by PID %d", e.PID)
 }
 
 type local struct {
-	path string
-	fs   afero.Fs
+	path        string
+	fs          afero.Fs
+	lockTimeout time.Duration
+	backups     int
 }
 
 type localFactory struct{}
@@ -66,6 +107,10 @@ func (f localFactory) Flags() *pflag.FlagSet {
 	return localFlagSet
 }
 
+func (f localFactory) Capabilities() []Capability {
+	return Capabilities(local{})
+}
+
 func init() {
 	Backends["local"] = localFactory{}
 }
@@ -95,13 +140,39 @@ func newLocal(
 	}
 	logger.WithField("path", path).Infof("using local path")
 
+	lockTimeout := defaultLockTimeout
+	opt = readOpt("local", "lock-timeout", conf)
+	if opt != nil {
+		lockTimeout, err = toDuration(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lock timeout: %w", err)
+		}
+	}
+
+	var backups int
+	opt = readOpt("local", "backups", conf)
+	if opt != nil {
+		backups, err = toInt(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup count: %w", err)
+		}
+		if backups < 0 {
+			return nil, fmt.Errorf("invalid backup count: %d", backups)
+		}
+	}
+
 	fs := afero.NewOsFs()
 	_, err = fs.Stat(path)
 	if err != nil && !errors.Is(err, afero.ErrFileNotFound) {
 		return nil, fmt.Errorf("invalid location: %s", path)
 	}
 
-	return local{path: path, fs: fs}, nil
+	return local{
+		path:        path,
+		fs:          fs,
+		lockTimeout: lockTimeout,
+		backups:     backups,
+	}, nil
 }
 
 func (l local) Exists() (bool, error) {
@@ -127,7 +198,15 @@ func (l local) SaveContext(ctx context.Context, data []byte) error {
 	logger := getLogger(ctx)
 	logger.WithField("path", l.path).
 		Info("writing encrypted data to local storage")
-	return afero.WriteFile(l.fs, l.path, data, 0o600)
+
+	if l.backups > 0 {
+		err := l.rotateBackups(ctx)
+		if err != nil {
+			return fmt.Errorf("could not backup store: %w", err)
+		}
+	}
+
+	return writeFile(l.fs, l.path, data)
 }
 
 func (l local) Load() ([]byte, error) {
@@ -138,5 +217,359 @@ func (l local) LoadContext(ctx context.Context) ([]byte, error) {
 	logger := getLogger(ctx)
 	logger.WithField("path", l.path).
 		Info("reading encrypted data from local storage")
-	return afero.ReadFile(l.fs, l.path)
+	data, err := afero.ReadFile(l.fs, l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &NotFoundError{Err: err}
+	}
+	return data, err
+}
+
+func (l local) LoadRevision() ([]byte, Revision, error) {
+	return l.LoadRevisionContext(context.Background())
+}
+
+func (l local) LoadRevisionContext(
+	ctx context.Context,
+) ([]byte, Revision, error) {
+	data, err := l.LoadContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	fi, err := l.fs.Stat(l.path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, localRevision(fi, data), nil
+}
+
+func (l local) SaveRevision(data []byte, rev Revision) error {
+	return l.SaveRevisionContext(context.Background(), data, rev)
+}
+
+func (l local) SaveRevisionContext(
+	ctx context.Context,
+	data []byte,
+	rev Revision,
+) error {
+	logger := getLogger(ctx)
+	logger.
+		WithField("path", l.path).
+		WithField("revision", rev).
+		Info("checking store revision")
+
+	cur, err := l.revision()
+	if err != nil {
+		return err
+	}
+	if cur != rev {
+		return &ConflictError{}
+	}
+
+	return l.SaveContext(ctx, data)
+}
+
+func (l local) Delete() error {
+	return l.DeleteContext(context.Background())
+}
+
+// DeleteContext removes the store file. The backups of the store are kept.
+func (l local) DeleteContext(ctx context.Context) error {
+	logger := getLogger(ctx)
+	logger.WithField("path", l.path).Info("deleting store file")
+
+	err := l.fs.Remove(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &NotFoundError{Err: err}
+	}
+	return err
+}
+
+// revision returns the current revision of the store file, or the empty
+// revision if the file does not exist.
+func (l local) revision() (Revision, error) {
+	fi, err := l.fs.Stat(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	data, err := afero.ReadFile(l.fs, l.path)
+	if err != nil {
+		return "", err
+	}
+	return localRevision(fi, data), nil
+}
+
+// localRevision identifies a version of a store file by its inode,
+// modification time and a hash of its contents.
+func localRevision(fi os.FileInfo, data []byte) Revision {
+	return Revision(fmt.Sprintf(
+		"%d-%d-%x",
+		inode(fi),
+		fi.ModTime().UnixNano(),
+		sha256.Sum256(data),
+	))
+}
+
+// writeFile atomically replaces the contents of the file at path with data.
+// data is written and synced to a temporary file in the same directory, which
+// is then renamed over the file, so that a failed write never leaves a
+// truncated file.
+func writeFile(fs afero.Fs, path string, data []byte) (err error) {
+	dir := filepath.Dir(path)
+	f, err := afero.TempFile(fs, dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = fs.Remove(tmpPath)
+		}
+	}()
+
+	n, err := f.Write(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return io.ErrShortWrite
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = fs.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(fs, dir)
+}
+
+func (l local) ListBackups() ([]Backup, error) {
+	return l.ListBackupsContext(context.Background())
+}
+
+func (l local) ListBackupsContext(ctx context.Context) ([]Backup, error) {
+	logger := getLogger(ctx)
+	logger.WithField("path", l.path).Info("listing store backups")
+
+	var backups []Backup
+	for n := 1; n <= l.backups; n++ {
+		fi, err := l.fs.Stat(l.backupPath(n))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		backups = append(backups, Backup{
+			Index:   n,
+			ModTime: fi.ModTime(),
+			Size:    fi.Size(),
+		})
+	}
+	return backups, nil
+}
+
+func (l local) LoadBackup(n int) ([]byte, error) {
+	return l.LoadBackupContext(context.Background(), n)
+}
+
+func (l local) LoadBackupContext(ctx context.Context, n int) ([]byte, error) {
+	logger := getLogger(ctx)
+	logger.
+		WithField("path", l.backupPath(n)).
+		Info("reading encrypted data from store backup")
+
+	if n < 1 {
+		return nil, fmt.Errorf("invalid backup: %d", n)
+	}
+	data, err := afero.ReadFile(l.fs, l.backupPath(n))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no backup %d", n)
+	}
+	return data, err
+}
+
+func (l local) ListStores() ([]string, error) {
+	return l.ListStoresContext(context.Background())
+}
+
+// ListStoresContext lists the files in the directory of the store, or in the
+// store path itself if it is a directory.
+func (l local) ListStoresContext(ctx context.Context) ([]string, error) {
+	dir := l.path
+	fi, err := l.fs.Stat(l.path)
+	if err != nil || !fi.IsDir() {
+		dir = filepath.Dir(l.path)
+	}
+
+	logger := getLogger(ctx)
+	logger.WithField("path", dir).Info("listing stores in directory")
+
+	infos, err := afero.ReadDir(l.fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() {
+			names = append(names, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return filterStores(names), nil
+}
+
+// backupPath returns the path of the n-th most recent backup.
+func (l local) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// rotateBackups shifts the existing backups by one, dropping the oldest, and
+// copies the current store file to the most recent backup.
+func (l local) rotateBackups(ctx context.Context) error {
+	logger := getLogger(ctx)
+
+	data, err := afero.ReadFile(l.fs, l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	logger.WithField("backups", l.backups).Info("rotating store backups")
+	for n := l.backups - 1; n >= 1; n-- {
+		err = l.fs.Rename(l.backupPath(n), l.backupPath(n+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return writeFile(l.fs, l.backupPath(1), data)
+}
+
+func (l local) Lock() (func() error, error) {
+	return l.LockContext(context.Background())
+}
+
+// LockContext acquires an advisory lock on a lock file next to the store file.
+// The store file itself cannot be locked, since it is replaced on every save.
+func (l local) LockContext(ctx context.Context) (func() error, error) {
+	return lockFile(ctx, l.fs, l.path+".lock", false, l.lockTimeout)
+}
+
+// lockFile acquires an advisory lock on the file at lockPath in fs, creating
+// it if needed, waiting at most timeout for another process to release it.
+// The lock is exclusive, or shared with other shared locks if shared is true.
+// The lock file holds the PID of the process holding an exclusive lock.
+// Returns a function releasing the lock.
+func lockFile(
+	ctx context.Context,
+	fs afero.Fs,
+	lockPath string,
+	shared bool,
+	timeout time.Duration,
+) (func() error, error) {
+	logger := getLogger(ctx).
+		WithField("path", lockPath).
+		WithField("shared", shared)
+	logger.Info("locking file")
+
+	f, err := fs.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = flock(f, shared)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errLockUnsupported) {
+			logger.Warn("file locking is not supported, file is not locked")
+			return f.Close, nil
+		}
+		if !errors.Is(err, errLocked) {
+			_ = f.Close()
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			lockedErr := &LockedError{PID: readLockPID(f)}
+			_ = f.Close()
+			return nil, lockedErr
+		}
+
+		logger.Info("file is locked, waiting")
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	if shared {
+		unlock := func() error {
+			logger.Info("unlocking file")
+			// Closing the file releases the lock
+			return f.Close()
+		}
+		return unlock, nil
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	unlock := func() error {
+		logger.Info("unlocking file")
+		_ = f.Truncate(0)
+		// Closing the file releases the lock
+		return f.Close()
+	}
+	return unlock, nil
+}
+
+// readLockPID returns the PID written in the lock file, or 0 if it cannot be
+// read.
+func readLockPID(f afero.File) int {
+	data := make([]byte, 32)
+	n, _ := f.ReadAt(data, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// toDuration converts a configuration value to a duration. Strings are parsed
+// as Go durations (e.g. "1m30s"), and numbers are read as seconds.
+func toDuration(opt interface{}) (time.Duration, error) {
+	switch v := opt.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		return time.ParseDuration(v)
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("not a duration: (%T)%v", opt, opt)
 }
@@ -36,4 +36,36 @@ func TestLocalFactory(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
+
+	_, err = f.New(map[string]interface{}{
+		"local-path":         "/tmp/store.scrt",
+		"local-lock-timeout": "1m30s",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = f.New(map[string]interface{}{
+		"local-path":         "/tmp/store.scrt",
+		"local-lock-timeout": "toto",
+	})
+	if err == nil {
+		t.Error("expected error")
+	}
+
+	_, err = f.New(map[string]interface{}{
+		"local-path":    "/tmp/store.scrt",
+		"local-backups": 5,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = f.New(map[string]interface{}{
+		"local-path":    "/tmp/store.scrt",
+		"local-backups": -1,
+	})
+	if err == nil {
+		t.Error("expected error")
+	}
 }
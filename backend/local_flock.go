@@ -0,0 +1,42 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package backend
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// flock acquires an exclusive lock on f, or a shared lock if shared is true,
+// without blocking. Returns errLocked if the file is already locked.
+func flock(f afero.File, shared bool) error {
+	fd, ok := f.(interface{ Fd() uintptr })
+	if !ok {
+		return errLockUnsupported
+	}
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err := syscall.Flock(int(fd.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
@@ -15,9 +15,13 @@
 package backend
 
 import (
+	"context"
+	"errors"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 
@@ -43,6 +47,11 @@ func TestLocalExists(t *testing.T) {
 	if exists {
 		t.Error("expected store not to exist")
 	}
+	_, err = b.Load()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Errorf("expected not found error, got %v", err)
+	}
 
 	b.path = path
 	exists, err = b.Exists()
@@ -84,3 +93,239 @@ func TestLocalSaveLoad(t *testing.T) {
 		t.Fatalf("expected %#v, got %#v", data, got)
 	}
 }
+
+func TestLocalSaveLoadRevision(t *testing.T) {
+	path := "/tmp/store.scrt"
+	fs := afero.NewMemMapFs()
+
+	s := store.NewStore()
+	data, _ := store.WriteStore([]byte("password"), s)
+
+	b := local{path: path, fs: fs}
+	err := b.SaveRevision(data, "1")
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	err = b.SaveRevision(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, rev, err := b.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, got) {
+		t.Fatalf("expected %#v, got %#v", data, got)
+	}
+	if rev == "" {
+		t.Fatal("expected non-empty revision")
+	}
+
+	err = b.SaveRevision(data, "")
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	err = s.Set("hello", []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, _ := store.WriteStore([]byte("password"), s)
+	err = b.SaveRevision(newData, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.SaveRevision(data, rev)
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+}
+
+// failingFs is an afero.Fs whose files can only be partially written, like on
+// a full disk.
+type failingFs struct {
+	afero.Fs
+}
+
+func (fs failingFs) OpenFile(
+	name string,
+	flag int,
+	perm os.FileMode,
+) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return failingFile{File: f}, nil
+}
+
+type failingFile struct {
+	afero.File
+}
+
+func (f failingFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("no space left on device")
+}
+
+func TestLocalSaveFailure(t *testing.T) {
+	path := "/tmp/store.scrt"
+	memFs := afero.NewMemMapFs()
+
+	s := store.NewStore()
+	data, _ := store.WriteStore([]byte("password"), s)
+
+	b := local{path: path, fs: memFs}
+	err := b.Save(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Set("hello", []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, _ := store.WriteStore([]byte("password"), s)
+
+	b.fs = failingFs{Fs: memFs}
+	err = b.Save(newData)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, got) {
+		t.Fatalf("expected %#v, got %#v", data, got)
+	}
+
+	files, err := afero.ReadDir(memFs, "/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+}
+
+func TestLocalLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.scrt")
+	fs := afero.NewOsFs()
+
+	b1 := local{path: path, fs: fs, lockTimeout: 0}
+	b2 := local{path: path, fs: fs, lockTimeout: 200 * time.Millisecond}
+
+	unlock, err := b1.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b2.Lock()
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("expected locked error, got %v", err)
+	}
+	if lockedErr.PID != os.Getpid() {
+		t.Errorf("expected PID %d, got %d", os.Getpid(), lockedErr.PID)
+	}
+
+	err = unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err = b2.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalBackups(t *testing.T) {
+	b := local{path: "/tmp/store.scrt", fs: afero.NewMemMapFs(), backups: 2}
+	testBackups(t, b)
+}
+
+func TestLocalBackupsNested(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.scrt")
+	conf := readConf(
+		t,
+		localFactory{},
+		"local:\n  path: "+path+"\n  backups: 3\n",
+	)
+
+	b, err := newLocal(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.(local).backups != 3 {
+		t.Fatalf("expected 3 backups, got %d", b.(local).backups)
+	}
+}
+
+func TestLocalListStores(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{
+		"/tmp/a.scrt",
+		"/tmp/a.scrt.1",
+		"/tmp/a.scrt.lock",
+		"/tmp/.a.scrt.1234.tmp",
+		"/tmp/b.scrt",
+		"/tmp/sub/c.scrt",
+	} {
+		err := afero.WriteFile(fs, path, []byte("data"), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"/tmp/a.scrt", "/tmp/b.scrt"}
+	for _, path := range []string{"/tmp/a.scrt", "/tmp/new.scrt", "/tmp"} {
+		b := local{path: path, fs: fs}
+		got, err := b.ListStores()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: expected %#v, got %#v", path, want, got)
+		}
+	}
+}
+
+func TestLocalDelete(t *testing.T) {
+	path := "/tmp/store.scrt"
+	fs := afero.NewMemMapFs()
+
+	b := local{path: path, fs: fs}
+	err := b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected store not to exist")
+	}
+
+	err = b.Delete()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
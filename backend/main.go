@@ -16,6 +16,12 @@ package backend
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/discard"
@@ -27,15 +33,26 @@ var Backends = map[string]Factory{}
 
 // BackendNameList is an ordered list of backend names for listing in help
 // message.
-var BackendNameList = []string{"local", "s3", "git"}
+var BackendNameList = []string{
+	"local",
+	"s3",
+	"git",
+	"sftp",
+	"http",
+	"azblob",
+	"shared",
+}
 
-// Backend implements the common backend operations.
+// Backend implements the common backend operations. Backends holding resources,
+// e.g. connections or locks, also implement io.Closer, and must be closed when
+// they are no longer used.
 type Backend interface {
 	// Exists returns true if a store exists in the backend, false otherwise
 	Exists() (bool, error)
 	// Save persists encrypted data to the backend
 	Save(data []byte) error
-	// Load reads encrypted data from the backend
+	// Load reads encrypted data from the backend. Returns a *NotFoundError if
+	// the store does not exist.
 	Load() ([]byte, error)
 
 	ExistsContext(ctx context.Context) (bool, error)
@@ -43,6 +60,195 @@ type Backend interface {
 	LoadContext(ctx context.Context) ([]byte, error)
 }
 
+// ConditionalSaver is implemented by backends that can save a store only if
+// it was not modified since it was loaded.
+type ConditionalSaver interface {
+	// LoadRevision reads encrypted data from the backend, along with the
+	// revision of the data
+	LoadRevision() ([]byte, Revision, error)
+	// SaveRevision persists encrypted data to the backend, only if the data in
+	// the backend is still at revision rev. Returns a *ConflictError
+	// otherwise.
+	SaveRevision(data []byte, rev Revision) error
+
+	LoadRevisionContext(ctx context.Context) ([]byte, Revision, error)
+	SaveRevisionContext(ctx context.Context, data []byte, rev Revision) error
+}
+
+// Locker is implemented by backends that can lock a store for exclusive
+// access.
+type Locker interface {
+	// Lock acquires an exclusive lock on the store and returns a function
+	// releasing the lock
+	Lock() (func() error, error)
+	LockContext(ctx context.Context) (func() error, error)
+}
+
+// BackupKeeper is implemented by backends keeping backups of the previous
+// versions of a store.
+type BackupKeeper interface {
+	// ListBackups returns the backups of the store, most recent first
+	ListBackups() ([]Backup, error)
+	// LoadBackup reads the encrypted data of the n-th most recent backup
+	LoadBackup(n int) ([]byte, error)
+
+	ListBackupsContext(ctx context.Context) ([]Backup, error)
+	LoadBackupContext(ctx context.Context, n int) ([]byte, error)
+}
+
+// Versioned is implemented by backends keeping the history of a store, that
+// can read the store as it was in the past.
+type Versioned interface {
+	// ListVersions returns the versions of the store, from the most recent to
+	// the oldest
+	ListVersions() ([]Version, error)
+	// LoadVersion reads the encrypted data of the store at version. The
+	// format of version depends on the backend, e.g. a git revision
+	LoadVersion(version string) ([]byte, error)
+	// LoadAt reads the encrypted data of the store as it was at time t
+	LoadAt(t time.Time) ([]byte, error)
+
+	ListVersionsContext(ctx context.Context) ([]Version, error)
+	LoadVersionContext(ctx context.Context, version string) ([]byte, error)
+	LoadAtContext(ctx context.Context, t time.Time) ([]byte, error)
+}
+
+// Lister is implemented by backends that can list the stores next to the
+// configured store, e.g. in the same directory or under the same prefix.
+type Lister interface {
+	// ListStores returns the names of the stores, sorted, as they can be
+	// given in the backend configuration
+	ListStores() ([]string, error)
+
+	ListStoresContext(ctx context.Context) ([]string, error)
+}
+
+// Sharer is implemented by backends that can give temporary read access to
+// the encrypted data of a store, without access to the backend itself.
+type Sharer interface {
+	// Share returns a URL to download the encrypted data of the store, valid
+	// for the duration expires
+	Share(expires time.Duration) (string, error)
+
+	ShareContext(ctx context.Context, expires time.Duration) (string, error)
+}
+
+// Deleter is implemented by backends that can delete a store.
+type Deleter interface {
+	// Delete removes the store from the backend. Returns a *NotFoundError if
+	// the store does not exist.
+	Delete() error
+
+	DeleteContext(ctx context.Context) error
+}
+
+// Capability is an optional feature of a backend, provided by implementing
+// one of the optional interfaces.
+type Capability string
+
+// Capabilities of the backends, in the order they are reported.
+const (
+	// CapabilityConditionalSave is provided by ConditionalSaver
+	CapabilityConditionalSave Capability = "conditional-save"
+	// CapabilityLock is provided by Locker
+	CapabilityLock Capability = "lock"
+	// CapabilityBackups is provided by BackupKeeper
+	CapabilityBackups Capability = "backups"
+	// CapabilityVersions is provided by Versioned
+	CapabilityVersions Capability = "versions"
+	// CapabilityList is provided by Lister
+	CapabilityList Capability = "list"
+	// CapabilityShare is provided by Sharer
+	CapabilityShare Capability = "share"
+	// CapabilityDelete is provided by Deleter
+	CapabilityDelete Capability = "delete"
+)
+
+// Capabilities returns the capabilities of b, from the optional interfaces it
+// implements.
+func Capabilities(b Backend) []Capability {
+	var caps []Capability
+	if _, ok := b.(ConditionalSaver); ok {
+		caps = append(caps, CapabilityConditionalSave)
+	}
+	if _, ok := b.(Locker); ok {
+		caps = append(caps, CapabilityLock)
+	}
+	if _, ok := b.(BackupKeeper); ok {
+		caps = append(caps, CapabilityBackups)
+	}
+	if _, ok := b.(Versioned); ok {
+		caps = append(caps, CapabilityVersions)
+	}
+	if _, ok := b.(Lister); ok {
+		caps = append(caps, CapabilityList)
+	}
+	if _, ok := b.(Sharer); ok {
+		caps = append(caps, CapabilityShare)
+	}
+	if _, ok := b.(Deleter); ok {
+		caps = append(caps, CapabilityDelete)
+	}
+	return caps
+}
+
+// Version describes a version of a store.
+type Version struct {
+	// ID identifies the version, e.g. a git commit hash or an S3 version ID
+	ID string
+	// ModTime is the time the version was saved
+	ModTime time.Time
+	// Size is the size of the version data in bytes
+	Size int64
+}
+
+// Backup describes a backup of a store.
+type Backup struct {
+	// Index is the position of the backup, starting at 1 for the most recent
+	Index int
+	// ModTime is the time the backup was taken
+	ModTime time.Time
+	// Size is the size of the backup data in bytes
+	Size int64
+}
+
+// Revision is an opaque token identifying a version of the data in a backend.
+// The empty Revision identifies a store that does not exist.
+type Revision string
+
+// ConflictError is returned by a conditional save when the data in the
+// backend was modified since it was loaded.
+type ConflictError struct {
+	Err error
+}
+
+func (e *ConflictError) Error() string {
+	if e.Err == nil {
+		return "store was modified concurrently"
+	}
+	return fmt.Sprintf("store was modified concurrently: %s", e.Err)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError is returned when loading a store that does not exist.
+type NotFoundError struct {
+	Err error
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Err == nil {
+		return "store does not exist"
+	}
+	return fmt.Sprintf("store does not exist: %s", e.Err)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
 // Factory can instantiate a new Backend with New, and other static
 // backend-related functions.
 type Factory interface {
@@ -60,24 +266,169 @@ type Factory interface {
 	// Flags returns a pflag FlagSet containing the options related to the
 	// backend
 	Flags() *pflag.FlagSet
+	// Capabilities returns the capabilities of the backend
+	Capabilities() []Capability
+}
+
+// secretAnnotation is the flag annotation marking the flags holding secrets.
+const secretAnnotation = "scrt_secret"
+
+// markSecret marks the flags names of fs as holding secrets, e.g. passwords or
+// tokens, which must not be logged.
+func markSecret(fs *pflag.FlagSet, names ...string) {
+	for _, name := range names {
+		err := fs.SetAnnotation(name, secretAnnotation, []string{"true"})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// IsSecret returns true if the flag f holds a secret, which must not be logged.
+func IsSecret(f *pflag.Flag) bool {
+	_, ok := f.Annotations[secretAnnotation]
+	return ok
 }
 
+// readOpt reads the option name of the backend prefix in conf, either from
+// the flat key (e.g. "local-path") or from the nested key (e.g. "local" >
+// "path"). Since bound flags always appear in the configuration with their
+// default value, the nested key takes precedence over a flat key holding the
+// default value of an unchanged flag.
 func readOpt(prefix, name string, conf map[string]interface{}) interface{} {
 	var backendOpts map[string]interface{}
 	l, ok := conf[prefix]
 	if ok {
 		backendOpts, _ = l.(map[string]interface{})
 	}
-	opt, ok := conf[prefix+"-"+name]
-	if opt == "" || !ok {
-		if backendOpts != nil {
-			opt, ok = backendOpts[name]
+	flat, flatOK := conf[prefix+"-"+name]
+	if flat == "" {
+		flatOK = false
+	}
+	if flatOK && !isDefaultOpt(prefix, name, flat) {
+		return flat
+	}
+	if backendOpts != nil {
+		opt, ok := backendOpts[name]
+		if ok && opt != "" {
+			return opt
+		}
+	}
+	if flatOK {
+		return flat
+	}
+	return nil
+}
+
+// isDefaultOpt returns true if opt is the default value of the unchanged flag
+// of the option name of the backend prefix.
+func isDefaultOpt(prefix, name string, opt interface{}) bool {
+	f, ok := Backends[prefix]
+	if !ok {
+		return false
+	}
+	flag := f.Flags().Lookup(prefix + "-" + name)
+	if flag == nil || flag.Changed {
+		return false
+	}
+	return fmt.Sprint(opt) == flag.DefValue
+}
+
+// toInt converts a configuration value to an int.
+func toInt(opt interface{}) (int, error) {
+	switch v := opt.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("not an integer: %v", v)
+		}
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("not an integer: (%T)%v", opt, opt)
+}
+
+// toBool converts a configuration value to a bool.
+func toBool(opt interface{}) (bool, error) {
+	switch v := opt.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+	return false, fmt.Errorf("not a boolean: (%T)%v", opt, opt)
+}
+
+// Change describes an update of a store, e.g. for a commit message.
+type Change struct {
+	// Operation is the command updating the store, e.g. "set" or "unset"
+	Operation string
+	// Keys are the keys modified by the update
+	Keys []string
+}
+
+type changeKey struct{}
+
+// NewChangeContext returns a new context carrying change. Backends can read
+// the change when saving a store.
+func NewChangeContext(ctx context.Context, change Change) context.Context {
+	return context.WithValue(ctx, changeKey{}, change)
+}
+
+// ChangeFromContext returns the change carried by ctx, if any.
+func ChangeFromContext(ctx context.Context) (Change, bool) {
+	change, ok := ctx.Value(changeKey{}).(Change)
+	return change, ok
+}
+
+// filterStores removes the backups, lock files and temporary files of stores
+// from names, and sorts the remaining names.
+func filterStores(names []string) []string {
+	all := make(map[string]bool, len(names))
+	for _, name := range names {
+		all[name] = true
+	}
+
+	stores := make([]string, 0, len(names))
+	for _, name := range names {
+		base, ext, ok := cutLast(name, ".")
+		if ok && ext == "lock" && all[base] {
+			continue
+		}
+		if _, err := strconv.Atoi(ext); ok && err == nil && all[base] {
+			continue
+		}
+		if isTempStore(name) {
+			continue
 		}
+		stores = append(stores, name)
 	}
-	if opt == "" || !ok {
-		return nil
+	sort.Strings(stores)
+	return stores
+}
+
+// isTempStore returns true if name is a temporary file left by an interrupted
+// save, e.g. ".store.scrt.1234.tmp".
+func isTempStore(name string) bool {
+	base := filepath.Base(name)
+	if !strings.HasPrefix(base, ".") || !strings.HasSuffix(base, ".tmp") {
+		return false
+	}
+	store, _, ok := cutLast(strings.TrimSuffix(base[1:], ".tmp"), ".")
+	return ok && store != ""
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
 	}
-	return opt
+	return s[:i], s[i+len(sep):], true
 }
 
 func getLogger(ctx context.Context) log.Interface {
@@ -0,0 +1,298 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// testBackups saves successive versions of a store to b, which must keep 2
+// backups, and checks that the previous versions are kept as backups.
+func testBackups(t *testing.T, b Backend) {
+	t.Helper()
+
+	k, ok := b.(BackupKeeper)
+	if !ok {
+		t.Fatalf("%T does not keep backups", b)
+	}
+
+	backups, err := k.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 0 {
+		t.Fatalf("expected no backups, got %d", len(backups))
+	}
+
+	for i := 0; i < 4; i++ {
+		err = b.Save([]byte(fmt.Sprintf("v%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err = k.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %d", len(backups))
+	}
+	for i, backup := range backups {
+		if backup.Index != i+1 {
+			t.Errorf("expected backup %d, got %d", i+1, backup.Index)
+		}
+		if backup.Size != 2 {
+			t.Errorf("expected size 2, got %d", backup.Size)
+		}
+	}
+
+	for n, expected := range map[int]string{1: "v2", 2: "v1"} {
+		got, err := k.LoadBackup(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]byte(expected), got) {
+			t.Errorf("expected %#v, got %#v", []byte(expected), got)
+		}
+	}
+
+	_, err = k.LoadBackup(3)
+	if err == nil {
+		t.Error("expected error")
+	}
+	_, err = k.LoadBackup(0)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+// readConf reads the YAML configuration with the flags of the factory f bound,
+// as the commands do.
+func readConf(
+	t *testing.T,
+	f Factory,
+	yaml string,
+	args ...string,
+) map[string]interface{} {
+	t.Helper()
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.AddFlagSet(f.Flags())
+	err := flags.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flags.VisitAll(func(f *pflag.Flag) {
+			_ = f.Value.Set(f.DefValue)
+			f.Changed = false
+		})
+	})
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err = v.ReadConfig(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.BindPFlags(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v.AllSettings()
+}
+
+func TestReadOpt(t *testing.T) {
+	testCases := []struct {
+		yaml string
+		args []string
+		name string
+		want interface{}
+	}{
+		{
+			yaml: "local:\n  lock-timeout: 1m\n",
+			name: "lock-timeout",
+			want: "1m",
+		},
+		{
+			yaml: "local-lock-timeout: 1m\n",
+			name: "lock-timeout",
+			want: "1m",
+		},
+		{
+			yaml: "local:\n  lock-timeout: 1m\n",
+			args: []string{"--local-lock-timeout=2m"},
+			name: "lock-timeout",
+			want: "2m0s",
+		},
+		{
+			yaml: "local:\n  path: store.scrt\n",
+			name: "lock-timeout",
+			want: "10s",
+		},
+		{
+			yaml: "local:\n  backups: 3\n",
+			name: "backups",
+			want: 3,
+		},
+	}
+	for i, tc := range testCases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			conf := readConf(t, localFactory{}, tc.yaml, tc.args...)
+			got := readOpt("local", tc.name, conf)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Errorf("expected %#v, got %#v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSecretFlags(t *testing.T) {
+	secrets := map[string][]string{
+		"s3": {
+			"s3-secret-access-key",
+			"s3-session-token",
+			"s3-sse-customer-key",
+		},
+		"git": {
+			"git-token",
+			"git-ssh-key-passphrase",
+			"git-signing-key-passphrase",
+		},
+		"sftp": {"sftp-ssh-key-passphrase"},
+		"http": {"http-password", "http-token"},
+		"azblob": {
+			"azblob-connection-string",
+			"azblob-account-key",
+			"azblob-sas-token",
+		},
+		"shared": {"shared-url"},
+	}
+	for name, flags := range secrets {
+		fs := Backends[name].Flags()
+		for _, flag := range flags {
+			f := fs.Lookup(flag)
+			if f == nil || !IsSecret(f) {
+				t.Errorf("expected %s to be secret", flag)
+			}
+		}
+	}
+}
+
+func TestFilterStores(t *testing.T) {
+	got := filterStores([]string{
+		"b.scrt",
+		"a.scrt",
+		"a.scrt.1",
+		"a.scrt.12",
+		"a.scrt.lock",
+		"c.scrt.1",
+		"d.lock",
+		"e",
+		".a.scrt.1234.tmp",
+		"/tmp/.f.scrt.5f3a.tmp",
+		".tmp",
+		".g.tmp",
+	})
+	want := []string{
+		".g.tmp",
+		".tmp",
+		"a.scrt",
+		"b.scrt",
+		"c.scrt.1",
+		"d.lock",
+		"e",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	testCases := []struct {
+		f    Factory
+		want []Capability
+	}{
+		{
+			f: localFactory{},
+			want: []Capability{
+				CapabilityConditionalSave,
+				CapabilityLock,
+				CapabilityBackups,
+				CapabilityList,
+				CapabilityDelete,
+			},
+		},
+		{
+			f: s3Factory{},
+			want: []Capability{
+				CapabilityConditionalSave,
+				CapabilityBackups,
+				CapabilityVersions,
+				CapabilityList,
+				CapabilityShare,
+				CapabilityDelete,
+			},
+		},
+		{
+			f: gitFactory{},
+			want: []Capability{
+				CapabilityConditionalSave,
+				CapabilityVersions,
+				CapabilityList,
+				CapabilityDelete,
+			},
+		},
+		{
+			f: sftpFactory{},
+			want: []Capability{
+				CapabilityList,
+				CapabilityDelete,
+			},
+		},
+		{
+			f: httpFactory{},
+			want: []Capability{
+				CapabilityConditionalSave,
+				CapabilityDelete,
+			},
+		},
+		{
+			f: azblobFactory{},
+			want: []Capability{
+				CapabilityConditionalSave,
+				CapabilityDelete,
+			},
+		},
+		{
+			f:    sharedFactory{},
+			want: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		got := tc.f.Capabilities()
+		if !reflect.DeepEqual(tc.want, got) {
+			t.Errorf("%s: expected %v, got %v", tc.f.Name(), tc.want, got)
+		}
+	}
+}
@@ -17,14 +17,25 @@ package backend
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
 	"github.com/spf13/pflag"
 )
 
@@ -40,14 +51,83 @@ func init() {
 	s3FlagSet.String(
 		"s3-key",
 		"",
-		"path of the store object in the bucket (required)",
+		"path of the store object in the bucket, relative to the prefix",
+	)
+	s3FlagSet.String(
+		"s3-prefix",
+		"",
+		"prefix of the store objects in the bucket",
 	)
 	s3FlagSet.String("s3-region", "", "region of the S3 storage")
+	s3FlagSet.String(
+		"s3-profile",
+		"",
+		"AWS profile from the shared configuration files",
+	)
+	s3FlagSet.String("s3-access-key-id", "", "AWS access key ID")
+	s3FlagSet.String("s3-secret-access-key", "", "AWS secret access key")
+	s3FlagSet.String(
+		"s3-session-token",
+		"",
+		"AWS session token for temporary credentials",
+	)
+	s3FlagSet.String("s3-role-arn", "", "ARN of an IAM role to assume")
+	s3FlagSet.String(
+		"s3-external-id",
+		"",
+		"external ID when assuming the IAM role",
+	)
+	s3FlagSet.String(
+		"s3-role-session-name",
+		"",
+		"session name when assuming the IAM role",
+	)
 	s3FlagSet.String(
 		"s3-endpoint-url",
 		"",
 		"override default S3 endpoint URL",
 	)
+	s3FlagSet.Int(
+		"s3-backups",
+		0,
+		"number of previous versions of the store to keep as backups",
+	)
+	s3FlagSet.String(
+		"s3-sse",
+		"",
+		"server-side encryption of the store object (AES256 or aws:kms)",
+	)
+	s3FlagSet.String(
+		"s3-sse-kms-key-id",
+		"",
+		"ID of the KMS key for aws:kms server-side encryption",
+	)
+	s3FlagSet.String(
+		"s3-sse-customer-key",
+		"",
+		"base64-encoded 256-bit key for server-side encryption (SSE-C)",
+	)
+	s3FlagSet.String(
+		"s3-storage-class",
+		"",
+		"storage class of the store object, e.g. STANDARD_IA",
+	)
+	s3FlagSet.String(
+		"s3-tags",
+		"",
+		"tags of the store object, e.g. key1=value1,key2=value2",
+	)
+	s3FlagSet.String(
+		"s3-acl",
+		"",
+		"canned ACL of the store object, e.g. bucket-owner-full-control",
+	)
+	markSecret(
+		s3FlagSet,
+		"s3-secret-access-key",
+		"s3-session-token",
+		"s3-sse-customer-key",
+	)
 }
 
 type s3ClientAPI interface {
@@ -61,11 +141,62 @@ type s3ClientAPI interface {
 		params *s3.PutObjectInput,
 		optFns ...func(*s3.Options),
 	) (*s3.PutObjectOutput, error)
+	HeadObject(
+		ctx context.Context,
+		params *s3.HeadObjectInput,
+		optFns ...func(*s3.Options),
+	) (*s3.HeadObjectOutput, error)
+	CopyObject(
+		ctx context.Context,
+		params *s3.CopyObjectInput,
+		optFns ...func(*s3.Options),
+	) (*s3.CopyObjectOutput, error)
+	DeleteObject(
+		ctx context.Context,
+		params *s3.DeleteObjectInput,
+		optFns ...func(*s3.Options),
+	) (*s3.DeleteObjectOutput, error)
+	ListObjectVersions(
+		ctx context.Context,
+		params *s3.ListObjectVersionsInput,
+		optFns ...func(*s3.Options),
+	) (*s3.ListObjectVersionsOutput, error)
+	ListObjectsV2(
+		ctx context.Context,
+		params *s3.ListObjectsV2Input,
+		optFns ...func(*s3.Options),
+	) (*s3.ListObjectsV2Output, error)
+}
+
+type s3PresignAPI interface {
+	PresignGetObject(
+		ctx context.Context,
+		params *s3.GetObjectInput,
+		optFns ...func(*s3.PresignOptions),
+	) (*v4.PresignedHTTPRequest, error)
 }
 
 type s3Backend struct {
-	bucket, key string
-	client      s3ClientAPI
+	bucket, key  string
+	prefix       string
+	backups      int
+	sse          s3types.ServerSideEncryption
+	sseKMSKeyID  string
+	sseCustomer  *sseCustomerKey
+	storageClass s3types.StorageClass
+	tagging      string
+	acl          s3types.ObjectCannedACL
+	client       s3ClientAPI
+	presigner    s3PresignAPI
+}
+
+// sseCustomerKey is a customer-provided key for server-side encryption
+// (SSE-C).
+type sseCustomerKey struct {
+	// key is the base64-encoded key
+	key string
+	// md5 is the base64-encoded MD5 digest of the key
+	md5 string
 }
 
 type s3Factory struct{}
@@ -93,6 +224,10 @@ func (f s3Factory) Flags() *pflag.FlagSet {
 	return s3FlagSet
 }
 
+func (f s3Factory) Capabilities() []Capability {
+	return Capabilities(s3Backend{})
+}
+
 func init() {
 	Backends["s3"] = s3Factory{}
 }
@@ -117,19 +252,43 @@ func newS3(ctx context.Context, conf map[string]interface{}) (Backend, error) {
 	}
 	logger = logger.WithField("bucket", bucket)
 
+	var prefix string
+	opt = readOpt("s3", "prefix", conf)
+	if opt != nil && opt != "" {
+		var ok bool
+		prefix, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"prefix is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+		logger = logger.WithField("prefix", prefix)
+	}
+
+	// The key is optional with a prefix, e.g. to list the stores under the
+	// prefix
+	var key string
 	opt = readOpt("s3", "key", conf)
-	if opt == nil || opt == "" {
+	if (opt == nil || opt == "") && prefix == "" {
 		return nil, fmt.Errorf("missing key")
 	}
-	key, ok := opt.(string)
-	if !ok {
-		return nil, fmt.Errorf(
-			"key is not a string: (%T)%s",
-			key,
-			key,
-		)
+	if opt != nil && opt != "" {
+		var ok bool
+		key, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"key is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+		if prefix != "" {
+			key = joinS3Key(prefix, key)
+		}
+		logger = logger.WithField("key", key)
 	}
-	logger = logger.WithField("key", key)
 
 	opt = readOpt("s3", "endpoint-url", conf)
 	if opt != nil && opt != "" {
@@ -137,11 +296,10 @@ func newS3(ctx context.Context, conf map[string]interface{}) (Backend, error) {
 		if !ok {
 			return nil, fmt.Errorf("S3 endpoint url is not a string")
 		}
-		configOpts = append(
-			configOpts,
-			config.WithBaseEndpoint(endpoint),
-		)
+		// Only override the S3 endpoint, other services (e.g. STS) use
+		// their default endpoints
 		clientOpts = append(clientOpts, func(o *s3.Options) {
+			o.BaseEndpoint = aws.String(endpoint)
 			o.UsePathStyle = true
 		})
 		logger = logger.WithField("endpoint URL", endpoint)
@@ -157,49 +315,326 @@ func newS3(ctx context.Context, conf map[string]interface{}) (Backend, error) {
 		logger = logger.WithField("region", region)
 	}
 
+	opt = readOpt("s3", "profile", conf)
+	if opt != nil && opt != "" {
+		profile, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 profile is not a string")
+		}
+		configOpts = append(
+			configOpts,
+			config.WithSharedConfigProfile(profile),
+		)
+		logger = logger.WithField("profile", profile)
+	}
+
+	var accessKeyID, secretAccessKey, sessionToken string
+	opt = readOpt("s3", "access-key-id", conf)
+	if opt != nil && opt != "" {
+		accessKeyID, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 access key ID is not a string")
+		}
+	}
+	opt = readOpt("s3", "secret-access-key", conf)
+	if opt != nil && opt != "" {
+		secretAccessKey, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 secret access key is not a string")
+		}
+	}
+	opt = readOpt("s3", "session-token", conf)
+	if opt != nil && opt != "" {
+		sessionToken, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 session token is not a string")
+		}
+	}
+	if (accessKeyID == "") != (secretAccessKey == "") {
+		return nil, fmt.Errorf(
+			"S3 access key ID and secret access key must be set together",
+		)
+	}
+	if sessionToken != "" && accessKeyID == "" {
+		return nil, fmt.Errorf("S3 session token requires an access key")
+	}
+	if accessKeyID != "" {
+		configOpts = append(
+			configOpts,
+			config.WithCredentialsProvider(
+				credentials.NewStaticCredentialsProvider(
+					accessKeyID,
+					secretAccessKey,
+					sessionToken,
+				),
+			),
+		)
+		logger = logger.WithField("access_key_id", accessKeyID)
+	}
+
+	var roleARN, externalID, roleSessionName string
+	opt = readOpt("s3", "role-arn", conf)
+	if opt != nil && opt != "" {
+		roleARN, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 role ARN is not a string")
+		}
+		logger = logger.WithField("role_arn", roleARN)
+	}
+	opt = readOpt("s3", "external-id", conf)
+	if opt != nil && opt != "" {
+		externalID, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 external ID is not a string")
+		}
+	}
+	opt = readOpt("s3", "role-session-name", conf)
+	if opt != nil && opt != "" {
+		roleSessionName, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 role session name is not a string")
+		}
+	}
+	if roleARN == "" && (externalID != "" || roleSessionName != "") {
+		return nil, fmt.Errorf(
+			"S3 external ID and role session name require a role ARN",
+		)
+	}
+
+	var backups int
+	opt = readOpt("s3", "backups", conf)
+	if opt != nil {
+		var err error
+		backups, err = toInt(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup count: %w", err)
+		}
+		if backups < 0 {
+			return nil, fmt.Errorf("invalid backup count: %d", backups)
+		}
+	}
+
+	var sse s3types.ServerSideEncryption
+	opt = readOpt("s3", "sse", conf)
+	if opt != nil && opt != "" {
+		v, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 SSE is not a string: (%T)%s", opt, opt)
+		}
+		sse = s3types.ServerSideEncryption(v)
+		if !isS3Enum(sse, sse.Values()) {
+			return nil, fmt.Errorf("invalid S3 SSE: %s", v)
+		}
+		logger = logger.WithField("sse", sse)
+	}
+
+	var sseKMSKeyID string
+	opt = readOpt("s3", "sse-kms-key-id", conf)
+	if opt != nil && opt != "" {
+		sseKMSKeyID, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"S3 SSE KMS key ID is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+		if sse == "" {
+			sse = s3types.ServerSideEncryptionAwsKms
+		}
+		if sse != s3types.ServerSideEncryptionAwsKms &&
+			sse != s3types.ServerSideEncryptionAwsKmsDsse {
+			return nil, fmt.Errorf(
+				"S3 SSE KMS key ID requires aws:kms encryption, got %s",
+				sse,
+			)
+		}
+		logger = logger.WithField("sse_kms_key_id", sseKMSKeyID)
+	}
+
+	var sseCustomer *sseCustomerKey
+	opt = readOpt("s3", "sse-customer-key", conf)
+	if opt != nil && opt != "" {
+		v, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 SSE customer key is not a string")
+		}
+		if sse != "" {
+			return nil, fmt.Errorf(
+				"S3 SSE customer key cannot be used with %s encryption",
+				sse,
+			)
+		}
+		var err error
+		sseCustomer, err = newSSECustomerKey(v)
+		if err != nil {
+			return nil, err
+		}
+		logger = logger.WithField("sse_customer_key_md5", sseCustomer.md5)
+	}
+
+	var storageClass s3types.StorageClass
+	opt = readOpt("s3", "storage-class", conf)
+	if opt != nil && opt != "" {
+		v, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"S3 storage class is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+		storageClass = s3types.StorageClass(v)
+		if !isS3Enum(storageClass, storageClass.Values()) {
+			return nil, fmt.Errorf("invalid S3 storage class: %s", v)
+		}
+		logger = logger.WithField("storage_class", storageClass)
+	}
+
+	var tagging string
+	opt = readOpt("s3", "tags", conf)
+	if opt != nil && opt != "" {
+		var err error
+		tagging, err = toS3Tagging(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 tags: %w", err)
+		}
+		logger = logger.WithField("tags", tagging)
+	}
+
+	var acl s3types.ObjectCannedACL
+	opt = readOpt("s3", "acl", conf)
+	if opt != nil && opt != "" {
+		v, ok := opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("S3 ACL is not a string: (%T)%s", opt, opt)
+		}
+		acl = s3types.ObjectCannedACL(v)
+		if !isS3Enum(acl, acl.Values()) {
+			return nil, fmt.Errorf("invalid S3 ACL: %s", v)
+		}
+		logger = logger.WithField("acl", acl)
+	}
+
 	logger.Info("using S3 object")
 
 	cfg, err := config.LoadDefaultConfig(ctx, configOpts...)
 	if err != nil {
 		return nil, err
 	}
+	if roleARN != "" {
+		// The role is assumed with the credentials from the configuration
+		provider := stscreds.NewAssumeRoleProvider(
+			sts.NewFromConfig(cfg),
+			roleARN,
+			func(o *stscreds.AssumeRoleOptions) {
+				if externalID != "" {
+					o.ExternalID = aws.String(externalID)
+				}
+				if roleSessionName != "" {
+					o.RoleSessionName = roleSessionName
+				}
+			},
+		)
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
 	client := s3.NewFromConfig(cfg, clientOpts...)
 
 	return s3Backend{
-		bucket: bucket,
-		key:    key,
-		client: client,
+		bucket:       bucket,
+		key:          key,
+		prefix:       prefix,
+		backups:      backups,
+		sse:          sse,
+		sseKMSKeyID:  sseKMSKeyID,
+		sseCustomer:  sseCustomer,
+		storageClass: storageClass,
+		tagging:      tagging,
+		acl:          acl,
+		client:       client,
+		presigner:    s3.NewPresignClient(client),
+	}, nil
+}
+
+// newSSECustomerKey decodes a base64-encoded 256-bit key for SSE-C.
+func newSSECustomerKey(encoded string) (*sseCustomerKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 SSE customer key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf(
+			"invalid S3 SSE customer key: expected 256 bits, got %d",
+			len(key)*8,
+		)
+	}
+	sum := md5.Sum(key)
+	return &sseCustomerKey{
+		key: encoded,
+		md5: base64.StdEncoding.EncodeToString(sum[:]),
 	}, nil
 }
 
+// isS3Enum returns true if v is one of values.
+func isS3Enum[T ~string](v T, values []T) bool {
+	for _, value := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// toS3Tagging converts tags to the URL-encoded format of S3 object tagging.
+// tags are either a map, or a string of comma-separated key=value pairs.
+func toS3Tagging(tags interface{}) (string, error) {
+	values := url.Values{}
+	switch v := tags.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			values.Set(k, fmt.Sprint(val))
+		}
+	case map[string]string:
+		for k, val := range v {
+			values.Set(k, val)
+		}
+	case string:
+		for _, pair := range strings.Split(v, ",") {
+			k, val, ok := strings.Cut(pair, "=")
+			k = strings.TrimSpace(k)
+			if !ok || k == "" {
+				return "", fmt.Errorf("not a key=value pair: %s", pair)
+			}
+			values.Set(k, strings.TrimSpace(val))
+		}
+	default:
+		return "", fmt.Errorf("not a string or a map: (%T)%v", tags, tags)
+	}
+	return values.Encode(), nil
+}
+
 func (s s3Backend) Exists() (bool, error) {
 	return s.ExistsContext(context.Background())
 }
 
 func (s s3Backend) ExistsContext(ctx context.Context) (bool, error) {
+	if s.key == "" {
+		return false, errS3MissingKey
+	}
+
 	logger := getLogger(ctx)
 	logger.
 		WithField("bucket", s.bucket).
 		WithField("key", s.key).
 		Info("checking store existence")
 
-	req := &s3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(s.key),
-	}
-	res, err := s.client.GetObject(ctx, req)
+	_, err := s.client.HeadObject(ctx, s.headObjectInput(s.key))
 	if err != nil {
-		var noSuchBucket *s3types.NoSuchBucket
-		var noSuchKey *s3types.NoSuchKey
-		if errors.As(err, &noSuchBucket) ||
-			errors.As(err, &noSuchKey) {
+		if isS3NotFound(err) {
 			return false, nil
 		}
 		return false, err
 	}
-	if err = res.Body.Close(); err != nil {
-		return false, err
-	}
 	return true, nil
 }
 
@@ -208,18 +643,22 @@ func (s s3Backend) Save(data []byte) error {
 }
 
 func (s s3Backend) SaveContext(ctx context.Context, data []byte) error {
+	if s.key == "" {
+		return errS3MissingKey
+	}
+
 	logger := getLogger(ctx)
 	logger.
 		WithField("bucket", s.bucket).
 		WithField("key", s.key).
 		Info("writing encrypted data to S3 storage")
 
-	req := &s3.PutObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(s.key),
-		Body:   bytes.NewReader(data),
+	err := s.rotateBackups(ctx)
+	if err != nil {
+		return fmt.Errorf("could not backup store: %w", err)
 	}
-	_, err := s.client.PutObject(ctx, req)
+
+	_, err = s.client.PutObject(ctx, s.putObjectInput(data))
 	if err != nil {
 		return err
 	}
@@ -231,24 +670,593 @@ func (s s3Backend) Load() ([]byte, error) {
 }
 
 func (s s3Backend) LoadContext(ctx context.Context) ([]byte, error) {
+	data, _, err := s.LoadRevisionContext(ctx)
+	return data, err
+}
+
+func (s s3Backend) LoadRevision() ([]byte, Revision, error) {
+	return s.LoadRevisionContext(context.Background())
+}
+
+func (s s3Backend) LoadRevisionContext(
+	ctx context.Context,
+) ([]byte, Revision, error) {
+	if s.key == "" {
+		return nil, "", errS3MissingKey
+	}
+
 	logger := getLogger(ctx)
 	logger.
 		WithField("bucket", s.bucket).
 		WithField("key", s.key).
 		Info("reading encrypted data from S3 storage")
 
-	req := &s3.GetObjectInput{
+	res, err := s.client.GetObject(ctx, s.getObjectInput(s.key))
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, "", &NotFoundError{Err: err}
+		}
+		return nil, "", err
+	}
+	defer func() { _ = res.Body.Close() }()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, Revision(aws.ToString(res.ETag)), nil
+}
+
+func (s s3Backend) SaveRevision(data []byte, rev Revision) error {
+	return s.SaveRevisionContext(context.Background(), data, rev)
+}
+
+func (s s3Backend) SaveRevisionContext(
+	ctx context.Context,
+	data []byte,
+	rev Revision,
+) error {
+	if s.key == "" {
+		return errS3MissingKey
+	}
+
+	logger := getLogger(ctx)
+	logger.
+		WithField("bucket", s.bucket).
+		WithField("key", s.key).
+		WithField("etag", rev).
+		Info("writing encrypted data to S3 storage")
+
+	// Read the previous data at the expected revision, and back it up only
+	// after the conditional write succeeded, so that conflicting writes do
+	// not rotate the backups
+	var prev []byte
+	if s.backups > 0 && rev != "" {
+		req := s.getObjectInput(s.key)
+		req.IfMatch = aws.String(string(rev))
+		res, err := s.client.GetObject(ctx, req)
+		if err != nil {
+			if isS3Conflict(err) || isS3NotFound(err) {
+				return &ConflictError{Err: err}
+			}
+			return err
+		}
+		prev, err = io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	req := s.putObjectInput(data)
+	if rev == "" {
+		req.IfNoneMatch = aws.String("*")
+	} else {
+		req.IfMatch = aws.String(string(rev))
+	}
+	_, err := s.client.PutObject(ctx, req)
+	if err != nil {
+		if isS3Conflict(err) {
+			return &ConflictError{Err: err}
+		}
+		return err
+	}
+
+	if prev != nil {
+		err = s.putBackup(ctx, prev)
+		if err != nil {
+			return fmt.Errorf(
+				"store was saved, but could not backup previous version: %w",
+				err,
+			)
+		}
+	}
+	return nil
+}
+
+func (s s3Backend) Delete() error {
+	return s.DeleteContext(context.Background())
+}
+
+// DeleteContext deletes the store object. The backups of the store are kept,
+// and so are the previous versions of the object in a versioned bucket.
+func (s s3Backend) DeleteContext(ctx context.Context) error {
+	if s.key == "" {
+		return errS3MissingKey
+	}
+
+	// DeleteObject succeeds whether the object exists or not
+	exists, err := s.ExistsContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &NotFoundError{}
+	}
+
+	logger := getLogger(ctx)
+	logger.
+		WithField("bucket", s.bucket).
+		WithField("key", s.key).
+		Info("deleting store object")
+
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(s.key),
+	})
+	return err
+}
+
+func (s s3Backend) ListBackups() ([]Backup, error) {
+	return s.ListBackupsContext(context.Background())
+}
+
+func (s s3Backend) ListBackupsContext(ctx context.Context) ([]Backup, error) {
+	if s.key == "" {
+		return nil, errS3MissingKey
+	}
+
+	logger := getLogger(ctx)
+	logger.
+		WithField("bucket", s.bucket).
+		WithField("key", s.key).
+		Info("listing store backups")
+
+	var backups []Backup
+	for n := 1; n <= s.backups; n++ {
+		res, err := s.client.HeadObject(ctx, s.headObjectInput(s.backupKey(n)))
+		if err != nil {
+			if isS3NotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		backups = append(backups, Backup{
+			Index:   n,
+			ModTime: aws.ToTime(res.LastModified),
+			Size:    aws.ToInt64(res.ContentLength),
+		})
 	}
+	return backups, nil
+}
+
+func (s s3Backend) LoadBackup(n int) ([]byte, error) {
+	return s.LoadBackupContext(context.Background(), n)
+}
+
+func (s s3Backend) LoadBackupContext(
+	ctx context.Context,
+	n int,
+) ([]byte, error) {
+	if s.key == "" {
+		return nil, errS3MissingKey
+	}
+
+	logger := getLogger(ctx)
+	logger.
+		WithField("bucket", s.bucket).
+		WithField("key", s.backupKey(n)).
+		Info("reading encrypted data from store backup")
+
+	if n < 1 {
+		return nil, fmt.Errorf("invalid backup: %d", n)
+	}
+	res, err := s.client.GetObject(ctx, s.getObjectInput(s.backupKey(n)))
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, fmt.Errorf("no backup %d", n)
+		}
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+	return io.ReadAll(res.Body)
+}
+
+func (s s3Backend) ListVersions() ([]Version, error) {
+	return s.ListVersionsContext(context.Background())
+}
+
+func (s s3Backend) ListVersionsContext(
+	ctx context.Context,
+) ([]Version, error) {
+	if s.key == "" {
+		return nil, errS3MissingKey
+	}
+
+	logger := getLogger(ctx)
+	logger.
+		WithField("bucket", s.bucket).
+		WithField("key", s.key).
+		Info("listing store object versions")
+
+	var versions []Version
+	req := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key),
+	}
+	for {
+		res, err := s.client.ListObjectVersions(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range res.Versions {
+			// Skip objects with the store key as prefix, e.g. backups
+			if aws.ToString(v.Key) != s.key {
+				continue
+			}
+			versions = append(versions, Version{
+				ID:      aws.ToString(v.VersionId),
+				ModTime: aws.ToTime(v.LastModified),
+				Size:    aws.ToInt64(v.Size),
+			})
+		}
+		if !aws.ToBool(res.IsTruncated) {
+			break
+		}
+		req.KeyMarker = res.NextKeyMarker
+		req.VersionIdMarker = res.NextVersionIdMarker
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].ModTime.After(versions[j].ModTime)
+	})
+	return versions, nil
+}
+
+func (s s3Backend) LoadVersion(version string) ([]byte, error) {
+	return s.LoadVersionContext(context.Background(), version)
+}
+
+func (s s3Backend) LoadVersionContext(
+	ctx context.Context,
+	version string,
+) ([]byte, error) {
+	if s.key == "" {
+		return nil, errS3MissingKey
+	}
+
+	logger := getLogger(ctx)
+	logger.
+		WithField("bucket", s.bucket).
+		WithField("key", s.key).
+		WithField("version", version).
+		Info("reading encrypted data from store object version")
+
+	req := s.getObjectInput(s.key)
+	req.VersionId = aws.String(version)
 	res, err := s.client.GetObject(ctx, req)
 	if err != nil {
+		if isS3NotFound(err) {
+			return nil, fmt.Errorf("no version %s", version)
+		}
 		return nil, err
 	}
 	defer func() { _ = res.Body.Close() }()
-	data, err := io.ReadAll(res.Body)
+	return io.ReadAll(res.Body)
+}
+
+func (s s3Backend) LoadAt(t time.Time) ([]byte, error) {
+	return s.LoadAtContext(context.Background(), t)
+}
+
+func (s s3Backend) LoadAtContext(
+	ctx context.Context,
+	t time.Time,
+) ([]byte, error) {
+	versions, err := s.ListVersionsContext(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
+	for _, v := range versions {
+		if !v.ModTime.After(t) {
+			return s.LoadVersionContext(ctx, v.ID)
+		}
+	}
+	return nil, fmt.Errorf("no version before %s", t.Format(time.RFC3339))
+}
+
+// errS3MissingKey is returned when accessing the store without a key, which
+// is only possible with a prefix.
+var errS3MissingKey = errors.New("missing key")
+
+// joinS3Key joins a key relative to prefix.
+func joinS3Key(prefix, key string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(key, "/")
+}
+
+func (s s3Backend) ListStores() ([]string, error) {
+	return s.ListStoresContext(context.Background())
+}
+
+// ListStoresContext lists the objects under the prefix, or under the
+// "directory" of the store key if there is no prefix. With a prefix, the
+// names are relative to the prefix.
+func (s s3Backend) ListStoresContext(ctx context.Context) ([]string, error) {
+	prefix := s.prefix
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	} else if i := strings.LastIndex(s.key, "/"); i >= 0 {
+		prefix = s.key[:i+1]
+	}
+
+	logger := getLogger(ctx)
+	logger.
+		WithField("bucket", s.bucket).
+		WithField("prefix", prefix).
+		Info("listing store objects")
+
+	var names []string
+	req := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		res, err := s.client.ListObjectsV2(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range res.Contents {
+			name := aws.ToString(o.Key)
+			if s.prefix != "" {
+				name = strings.TrimPrefix(name, prefix)
+			}
+			if name == "" || strings.HasSuffix(name, "/") {
+				// Skip "directory" placeholder objects
+				continue
+			}
+			names = append(names, name)
+		}
+		if !aws.ToBool(res.IsTruncated) {
+			break
+		}
+		req.ContinuationToken = res.NextContinuationToken
+	}
+	return filterStores(names), nil
+}
+
+// maxS3ShareExpires is the longest validity of a presigned URL.
+const maxS3ShareExpires = 7 * 24 * time.Hour
+
+func (s s3Backend) Share(expires time.Duration) (string, error) {
+	return s.ShareContext(context.Background(), expires)
+}
+
+// ShareContext returns a presigned URL to download the store object.
+func (s s3Backend) ShareContext(
+	ctx context.Context,
+	expires time.Duration,
+) (string, error) {
+	if s.key == "" {
+		return "", errS3MissingKey
+	}
+	if s.sseCustomer != nil {
+		// The customer key would have to be sent along with the URL
+		return "", fmt.Errorf(
+			"cannot share a store encrypted with a customer key",
+		)
+	}
+	if expires <= 0 {
+		return "", fmt.Errorf("invalid expiration: %s", expires)
+	}
+	if expires > maxS3ShareExpires {
+		return "", fmt.Errorf(
+			"invalid expiration: %s is longer than %s",
+			expires,
+			maxS3ShareExpires,
+		)
+	}
+
+	logger := getLogger(ctx)
+	logger.
+		WithField("bucket", s.bucket).
+		WithField("key", s.key).
+		WithField("expires", expires).
+		Info("presigning store object URL")
+
+	req, err := s.presigner.PresignGetObject(
+		ctx,
+		&s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key),
+		},
+		s3.WithPresignExpires(expires),
+	)
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// backupKey returns the key of the n-th most recent backup.
+func (s s3Backend) backupKey(n int) string {
+	return fmt.Sprintf("%s.%d", s.key, n)
+}
+
+// rotateBackups shifts the existing backups by one, dropping the oldest, and
+// copies the current store object to the most recent backup.
+func (s s3Backend) rotateBackups(ctx context.Context) error {
+	if s.backups == 0 {
+		return nil
+	}
+
+	err := s.shiftBackups(ctx)
+	if err != nil {
+		return err
+	}
+	err = s.copyObject(ctx, s.key, s.backupKey(1))
+	if err != nil && !isS3NotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// putBackup rotates the backups, and writes data as the most recent backup.
+func (s s3Backend) putBackup(ctx context.Context, data []byte) error {
+	err := s.shiftBackups(ctx)
+	if err != nil {
+		return err
+	}
+	req := s.putObjectInput(data)
+	req.Key = aws.String(s.backupKey(1))
+	_, err = s.client.PutObject(ctx, req)
+	return err
+}
+
+// shiftBackups moves each backup to the next index, dropping the oldest.
+func (s s3Backend) shiftBackups(ctx context.Context) error {
+	logger := getLogger(ctx)
+	logger.WithField("backups", s.backups).Info("rotating store backups")
+
+	for n := s.backups - 1; n >= 1; n-- {
+		err := s.copyObject(ctx, s.backupKey(n), s.backupKey(n+1))
+		if err != nil && !isS3NotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s s3Backend) copyObject(ctx context.Context, src, dst string) error {
+	segments := strings.Split(s.bucket+"/"+src, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	req := &s3.CopyObjectInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(dst),
+		CopySource:           aws.String(strings.Join(segments, "/")),
+		ServerSideEncryption: s.sse,
+		StorageClass:         s.storageClass,
+		ACL:                  s.acl,
+	}
+	if s.sseKMSKeyID != "" {
+		req.SSEKMSKeyId = aws.String(s.sseKMSKeyID)
+	}
+	if s.sseCustomer != nil {
+		req.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		req.SSECustomerKey = aws.String(s.sseCustomer.key)
+		req.SSECustomerKeyMD5 = aws.String(s.sseCustomer.md5)
+		req.CopySourceSSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		req.CopySourceSSECustomerKey = aws.String(s.sseCustomer.key)
+		req.CopySourceSSECustomerKeyMD5 = aws.String(s.sseCustomer.md5)
+	}
+	if s.tagging != "" {
+		req.Tagging = aws.String(s.tagging)
+		req.TaggingDirective = s3types.TaggingDirectiveReplace
+	}
+	_, err := s.client.CopyObject(ctx, req)
+	return err
+}
+
+// sseCustomerAlgorithm is the only algorithm supported by SSE-C.
+const sseCustomerAlgorithm = "AES256"
+
+// putObjectInput returns the request writing data to the store object, with
+// the encryption and object options.
+func (s s3Backend) putObjectInput(data []byte) *s3.PutObjectInput {
+	req := &s3.PutObjectInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(s.key),
+		Body:                 bytes.NewReader(data),
+		ServerSideEncryption: s.sse,
+		StorageClass:         s.storageClass,
+		ACL:                  s.acl,
+	}
+	if s.sseKMSKeyID != "" {
+		req.SSEKMSKeyId = aws.String(s.sseKMSKeyID)
+	}
+	if s.sseCustomer != nil {
+		req.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		req.SSECustomerKey = aws.String(s.sseCustomer.key)
+		req.SSECustomerKeyMD5 = aws.String(s.sseCustomer.md5)
+	}
+	if s.tagging != "" {
+		req.Tagging = aws.String(s.tagging)
+	}
+	return req
+}
+
+// getObjectInput returns the request reading the object at key, with the
+// SSE-C key if any.
+func (s s3Backend) getObjectInput(key string) *s3.GetObjectInput {
+	req := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if s.sseCustomer != nil {
+		req.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		req.SSECustomerKey = aws.String(s.sseCustomer.key)
+		req.SSECustomerKeyMD5 = aws.String(s.sseCustomer.md5)
+	}
+	return req
+}
+
+// headObjectInput returns the request reading the metadata of the object at
+// key, with the SSE-C key if any.
+func (s s3Backend) headObjectInput(key string) *s3.HeadObjectInput {
+	req := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if s.sseCustomer != nil {
+		req.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		req.SSECustomerKey = aws.String(s.sseCustomer.key)
+		req.SSECustomerKeyMD5 = aws.String(s.sseCustomer.md5)
+	}
+	return req
+}
+
+// isS3NotFound returns true if err is the result of accessing an object that
+// does not exist.
+func isS3NotFound(err error) bool {
+	var noSuchBucket *s3types.NoSuchBucket
+	var noSuchKey *s3types.NoSuchKey
+	var notFound *s3types.NotFound
+	if errors.As(err, &noSuchBucket) ||
+		errors.As(err, &noSuchKey) ||
+		errors.As(err, &notFound) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "NoSuchBucket", "NoSuchKey", "NoSuchVersion", "NotFound":
+		return true
+	}
+	return false
+}
+
+// isS3Conflict returns true if err is the result of a failed conditional
+// write.
+func isS3Conflict(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
 }
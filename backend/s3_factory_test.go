@@ -15,7 +15,18 @@
 package backend
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 func TestS3Factory(t *testing.T) {
@@ -111,4 +122,214 @@ func TestS3Factory(t *testing.T) {
 	if err == nil {
 		t.Errorf("expected error")
 	}
+
+	_, err = f.New(map[string]interface{}{
+		"s3-bucket-name": "scrt-bucket",
+		"s3-key":         "/store.scrt",
+		"s3-backups":     "5",
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = f.New(map[string]interface{}{
+		"s3-bucket-name": "scrt-bucket",
+		"s3-key":         "/store.scrt",
+		"s3-backups":     "toto",
+	})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+
+	b, err := f.New(map[string]interface{}{
+		"s3-bucket-name": "scrt-bucket",
+		"s3-prefix":      "teams/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = f.New(map[string]interface{}{
+		"s3-bucket-name": "scrt-bucket",
+		"s3-prefix":      "teams/",
+		"s3-key":         "a/dev.scrt",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.(s3Backend).key != "teams/a/dev.scrt" {
+		t.Errorf("expected key under prefix, got %s", b.(s3Backend).key)
+	}
+
+	b, err = f.New(map[string]interface{}{
+		"s3": map[string]interface{}{
+			"bucket-name":    "scrt-bucket",
+			"key":            "/store.scrt",
+			"sse-kms-key-id": "alias/scrt",
+			"storage-class":  "STANDARD_IA",
+			"tags":           map[string]interface{}{"team": "ops"},
+			"acl":            "bucket-owner-full-control",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.(s3Backend).sse != "aws:kms" {
+		t.Errorf("expected aws:kms encryption, got %s", b.(s3Backend).sse)
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{"s3-sse": "toto"},
+		{"s3-sse": "AES256", "s3-sse-kms-key-id": "alias/scrt"},
+		{"s3-sse": "AES256", "s3-sse-customer-key": "dG90bw=="},
+		{"s3-sse-customer-key": "toto"},
+		{"s3-storage-class": "toto"},
+		{"s3-tags": "toto"},
+		{"s3-acl": "toto"},
+	} {
+		opts["s3-bucket-name"] = "scrt-bucket"
+		opts["s3-key"] = "/store.scrt"
+		_, err = f.New(opts)
+		if err == nil {
+			t.Errorf("%v: expected error", opts)
+		}
+	}
+}
+
+// s3Credentials returns the credentials of the S3 client of b.
+func s3Credentials(t *testing.T, b Backend) aws.Credentials {
+	t.Helper()
+	client := b.(s3Backend).client.(*s3.Client)
+	creds, err := client.Options().Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creds
+}
+
+func TestS3FactoryCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	err := os.WriteFile(
+		credentialsFile,
+		[]byte(
+			"[other]\n"+
+				"aws_access_key_id = PROFILEKEY\n"+
+				"aws_secret_access_key = profilesecret\n",
+		),
+		0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	f := s3Factory{}
+	b, err := f.New(map[string]interface{}{
+		"s3-bucket-name": "scrt-bucket",
+		"s3-key":         "/store.scrt",
+		"s3-region":      "us-east-1",
+		"s3-profile":     "other",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := s3Credentials(t, b)
+	if creds.AccessKeyID != "PROFILEKEY" {
+		t.Errorf("expected profile credentials, got %s", creds.AccessKeyID)
+	}
+
+	b, err = f.New(map[string]interface{}{
+		"s3": map[string]interface{}{
+			"bucket-name":       "scrt-bucket",
+			"key":               "/store.scrt",
+			"region":            "us-east-1",
+			"access-key-id":     "STATICKEY",
+			"secret-access-key": "staticsecret",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds = s3Credentials(t, b)
+	if creds.AccessKeyID != "STATICKEY" ||
+		creds.SecretAccessKey != "staticsecret" {
+		t.Errorf("expected static credentials, got %s", creds.AccessKeyID)
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{"s3-profile": "nonexistent"},
+		{"s3-access-key-id": "STATICKEY"},
+		{"s3-secret-access-key": "staticsecret"},
+		{"s3-session-token": "token"},
+		{"s3-external-id": "external"},
+		{"s3-role-session-name": "scrt"},
+	} {
+		opts["s3-bucket-name"] = "scrt-bucket"
+		opts["s3-key"] = "/store.scrt"
+		_, err = f.New(opts)
+		if err == nil {
+			t.Errorf("%v: expected error", opts)
+		}
+	}
+}
+
+func TestS3FactoryAssumeRole(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			err := r.ParseForm()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			form = r.PostForm
+			_, _ = fmt.Fprintf(w, `<AssumeRoleResponse>
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ROLEKEY</AccessKeyId>
+      <SecretAccessKey>rolesecret</SecretAccessKey>
+      <SessionToken>roletoken</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		},
+	))
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", srv.URL)
+
+	b, err := s3Factory{}.New(map[string]interface{}{
+		"s3-bucket-name":       "scrt-bucket",
+		"s3-key":               "/store.scrt",
+		"s3-region":            "us-east-1",
+		"s3-endpoint-url":      "http://localhost:123456",
+		"s3-access-key-id":     "STATICKEY",
+		"s3-secret-access-key": "staticsecret",
+		"s3-role-arn":          "arn:aws:iam::123456789012:role/scrt",
+		"s3-external-id":       "external",
+		"s3-role-session-name": "scrt-session",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := s3Credentials(t, b)
+	if creds.AccessKeyID != "ROLEKEY" || creds.SessionToken != "roletoken" {
+		t.Errorf("expected role credentials, got %s", creds.AccessKeyID)
+	}
+
+	want := map[string]string{
+		"Action":          "AssumeRole",
+		"RoleArn":         "arn:aws:iam::123456789012:role/scrt",
+		"ExternalId":      "external",
+		"RoleSessionName": "scrt-session",
+	}
+	for k, v := range want {
+		if form.Get(k) != v {
+			t.Errorf("expected %s=%s, got %s", k, v, form.Get(k))
+		}
+	}
 }
@@ -17,18 +17,48 @@ package backend
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 
 	"github.com/loderunner/scrt/store"
 )
 
+type mockS3Object struct {
+	data      []byte
+	etag      string
+	versionID string
+	modTime   time.Time
+}
+
+// mockS3Client is an in-memory versioned S3 bucket.
 type mockS3Client struct {
-	data []byte
+	objects map[string]mockS3Object
+	// versions are the versions of the objects, from the oldest to the most
+	// recent
+	versions map[string][]mockS3Object
+	// gets counts the calls to GetObject
+	gets int
+	// last requests, to check their options
+	lastGet  *s3.GetObjectInput
+	lastPut  *s3.PutObjectInput
+	lastHead *s3.HeadObjectInput
+	lastCopy *s3.CopyObjectInput
 }
 
 func (m *mockS3Client) GetObject(
@@ -36,11 +66,31 @@ func (m *mockS3Client) GetObject(
 	params *s3.GetObjectInput,
 	_ ...func(*s3.Options),
 ) (*s3.GetObjectOutput, error) {
-	if *params.Key != "/store.scrt" || m.data == nil {
+	m.gets++
+	m.lastGet = params
+	o, ok := m.objects[*params.Key]
+	if params.VersionId != nil {
+		ok = false
+		for _, v := range m.versions[*params.Key] {
+			if v.versionID == *params.VersionId {
+				o, ok = v, true
+			}
+		}
+		if !ok {
+			return nil, &smithy.GenericAPIError{Code: "NoSuchVersion"}
+		}
+	}
+	if !ok {
 		return nil, &s3types.NoSuchKey{}
 	}
+	if params.IfMatch != nil && *params.IfMatch != o.etag {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
 	return &s3.GetObjectOutput{
-		Body: io.NopCloser(bytes.NewReader(m.data)),
+		Body:          io.NopCloser(bytes.NewReader(o.data)),
+		ETag:          aws.String(o.etag),
+		ContentLength: aws.Int64(int64(len(o.data))),
+		LastModified:  aws.Time(o.modTime),
 	}, nil
 }
 
@@ -49,28 +99,172 @@ func (m *mockS3Client) PutObject(
 	params *s3.PutObjectInput,
 	_ ...func(*s3.Options),
 ) (*s3.PutObjectOutput, error) {
-	var err error
-	m.data, err = io.ReadAll(params.Body)
+	m.lastPut = params
+	o, exists := m.objects[*params.Key]
+	if params.IfNoneMatch != nil && exists ||
+		params.IfMatch != nil && *params.IfMatch != o.etag {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	data, err := io.ReadAll(params.Body)
 	if err != nil {
 		return nil, err
 	}
-	return &s3.PutObjectOutput{}, nil
+	o = m.put(*params.Key, data)
+	return &s3.PutObjectOutput{ETag: aws.String(o.etag)}, nil
+}
+
+func (m *mockS3Client) HeadObject(
+	_ context.Context,
+	params *s3.HeadObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.HeadObjectOutput, error) {
+	m.lastHead = params
+	o, ok := m.objects[*params.Key]
+	if !ok {
+		return nil, &s3types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{
+		ETag:          aws.String(o.etag),
+		ContentLength: aws.Int64(int64(len(o.data))),
+		LastModified:  aws.Time(o.modTime),
+	}, nil
+}
+
+func (m *mockS3Client) CopyObject(
+	_ context.Context,
+	params *s3.CopyObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.CopyObjectOutput, error) {
+	m.lastCopy = params
+	src, err := url.PathUnescape(*params.CopySource)
+	if err != nil {
+		return nil, err
+	}
+	_, key, _ := strings.Cut(src, "/")
+	o, ok := m.objects[key]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "NoSuchKey"}
+	}
+	m.put(*params.Key, o.data)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (m *mockS3Client) DeleteObject(
+	_ context.Context,
+	params *s3.DeleteObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.DeleteObjectOutput, error) {
+	// Versions are kept, as in a versioned bucket
+	delete(m.objects, *params.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3Client) ListObjectVersions(
+	_ context.Context,
+	params *s3.ListObjectVersionsInput,
+	_ ...func(*s3.Options),
+) (*s3.ListObjectVersionsOutput, error) {
+	var keys []string
+	for key := range m.versions {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	res := &s3.ListObjectVersionsOutput{}
+	for _, key := range keys {
+		versions := m.versions[key]
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			res.Versions = append(res.Versions, s3types.ObjectVersion{
+				Key:          aws.String(key),
+				VersionId:    aws.String(v.versionID),
+				LastModified: aws.Time(v.modTime),
+				Size:         aws.Int64(int64(len(v.data))),
+				IsLatest:     aws.Bool(i == len(versions)-1),
+			})
+		}
+	}
+	return res, nil
+}
+
+// mockS3ListPageSize is the number of objects returned by ListObjectsV2.
+const mockS3ListPageSize = 2
+
+func (m *mockS3Client) ListObjectsV2(
+	_ context.Context,
+	params *s3.ListObjectsV2Input,
+	_ ...func(*s3.Options),
+) (*s3.ListObjectsV2Output, error) {
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, aws.ToString(params.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if params.ContinuationToken != nil {
+		var err error
+		start, err = strconv.Atoi(*params.ContinuationToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+	end := start + mockS3ListPageSize
+	res := &s3.ListObjectsV2Output{}
+	if end < len(keys) {
+		res.IsTruncated = aws.Bool(true)
+		res.NextContinuationToken = aws.String(strconv.Itoa(end))
+	} else {
+		end = len(keys)
+	}
+	for _, key := range keys[start:end] {
+		res.Contents = append(res.Contents, s3types.Object{
+			Key:  aws.String(key),
+			Size: aws.Int64(int64(len(m.objects[key].data))),
+		})
+	}
+	return res, nil
+}
+
+func (m *mockS3Client) put(key string, data []byte) mockS3Object {
+	return m.putAt(key, data, time.Now())
+}
+
+func (m *mockS3Client) putAt(
+	key string,
+	data []byte,
+	modTime time.Time,
+) mockS3Object {
+	if m.objects == nil {
+		m.objects = make(map[string]mockS3Object)
+		m.versions = make(map[string][]mockS3Object)
+	}
+	o := mockS3Object{
+		data:      data,
+		etag:      fmt.Sprintf("\"%x\"", md5.Sum(data)),
+		versionID: fmt.Sprintf("v%d", len(m.versions[key])),
+		modTime:   modTime,
+	}
+	m.objects[key] = o
+	m.versions[key] = append(m.versions[key], o)
+	return o
 }
 
 func TestS3Exists(t *testing.T) {
+	client := &mockS3Client{}
 	b := s3Backend{
 		bucket: "test-bucket",
 		key:    "/nonexistent.scrt",
-		client: &mockS3Client{},
+		client: client,
 	}
 
 	s := store.NewStore()
 	data, _ := store.WriteStore([]byte("password"), s)
-
-	err := b.Save(data)
-	if err != nil {
-		t.Fatal(err)
-	}
+	client.put("/store.scrt", data)
 
 	exists, err := b.Exists()
 	if err != nil {
@@ -88,6 +282,32 @@ func TestS3Exists(t *testing.T) {
 	if !exists {
 		t.Error("expected store to exist")
 	}
+
+	if client.gets != 0 {
+		t.Errorf("expected no object download, got %d", client.gets)
+	}
+}
+
+func TestS3LoadNotFound(t *testing.T) {
+	client := &mockS3Client{}
+	b := s3Backend{
+		bucket: "test-bucket",
+		key:    "/nonexistent.scrt",
+		client: client,
+	}
+
+	_, err := b.Load()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	_, _, err = b.LoadRevision()
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if client.gets != 2 {
+		t.Errorf("expected 2 object downloads, got %d", client.gets)
+	}
 }
 
 func TestS3SaveLoad(t *testing.T) {
@@ -121,3 +341,468 @@ func TestS3SaveLoad(t *testing.T) {
 		t.Fatalf("expected %#v, got %#v", data, got)
 	}
 }
+
+func TestS3SaveLoadRevision(t *testing.T) {
+	s := store.NewStore()
+	data, _ := store.WriteStore([]byte("password"), s)
+
+	b := s3Backend{
+		bucket: "test-bucket",
+		key:    "/store.scrt",
+		client: &mockS3Client{},
+	}
+	err := b.SaveRevision(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.SaveRevision(data, "")
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	got, rev, err := b.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, got) {
+		t.Fatalf("expected %#v, got %#v", data, got)
+	}
+
+	err = s.Set("hello", []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, _ := store.WriteStore([]byte("password"), s)
+	err = b.SaveRevision(newData, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.SaveRevision(data, rev)
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+}
+
+func TestS3Backups(t *testing.T) {
+	b := s3Backend{
+		bucket:  "test-bucket",
+		key:     "/store.scrt",
+		backups: 2,
+		client:  &mockS3Client{},
+	}
+	testBackups(t, b)
+}
+
+func TestS3SaveRevisionBackups(t *testing.T) {
+	client := &mockS3Client{}
+	b := s3Backend{
+		bucket:  "test-bucket",
+		key:     "/store.scrt",
+		backups: 2,
+		client:  client,
+	}
+
+	for i := 0; i < 3; i++ {
+		err := b.Save([]byte(fmt.Sprintf("v%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, rev, err := b.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Conflicting writes, e.g. retries, leave the backups unchanged
+	for i := 0; i < 5; i++ {
+		err = b.SaveRevision([]byte("conflict"), rev)
+		var conflictErr *ConflictError
+		if !errors.As(err, &conflictErr) {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+	}
+	for n, expected := range map[int]string{1: "v2", 2: "v1"} {
+		got, err := b.LoadBackup(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != expected {
+			t.Errorf("expected backup %d %q, got %q", n, expected, got)
+		}
+	}
+
+	_, rev, err = b.LoadRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.SaveRevision([]byte("v4"), rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, expected := range map[int]string{1: "v3", 2: "v2"} {
+		got, err := b.LoadBackup(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != expected {
+			t.Errorf("expected backup %d %q, got %q", n, expected, got)
+		}
+	}
+}
+
+func TestS3Versions(t *testing.T) {
+	client := &mockS3Client{}
+	b := s3Backend{
+		bucket:  "test-bucket",
+		key:     "/store.scrt",
+		backups: 1,
+		client:  client,
+	}
+
+	versions, err := b.ListVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Fatalf("expected no versions, got %d", len(versions))
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		data := []byte(fmt.Sprintf("data%d", i))
+		client.putAt("/store.scrt", data, start.AddDate(0, i, 0))
+	}
+	// Objects with the store key as prefix are not versions of the store
+	client.put("/store.scrt.1", []byte("backup"))
+
+	versions, err = b.ListVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantIDs := []string{"v2", "v1", "v0"}
+	if len(versions) != len(wantIDs) {
+		t.Fatalf("expected %d versions, got %d", len(wantIDs), len(versions))
+	}
+	for i, v := range versions {
+		if v.ID != wantIDs[i] {
+			t.Errorf("%d: expected version %s, got %s", i, wantIDs[i], v.ID)
+		}
+		if v.Size != 5 {
+			t.Errorf("%d: expected size 5, got %d", i, v.Size)
+		}
+	}
+
+	got, err := b.LoadVersion("v0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("data0"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("data0"), got)
+	}
+	_, err = b.LoadVersion("v3")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	got, err = b.LoadAt(start.AddDate(0, 1, 15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte("data1"), got) {
+		t.Fatalf("expected %#v, got %#v", []byte("data1"), got)
+	}
+	_, err = b.LoadAt(start.AddDate(0, 0, -1))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestS3ObjectOptions(t *testing.T) {
+	client := &mockS3Client{}
+	b := s3Backend{
+		bucket:       "test-bucket",
+		key:          "/store.scrt",
+		backups:      1,
+		sse:          s3types.ServerSideEncryptionAwsKms,
+		sseKMSKeyID:  "alias/scrt",
+		storageClass: s3types.StorageClassStandardIa,
+		tagging:      "team=ops",
+		acl:          s3types.ObjectCannedACLBucketOwnerFullControl,
+		client:       client,
+	}
+
+	err := b.Save([]byte("v0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Save([]byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := client.lastPut
+	if put.ServerSideEncryption != b.sse ||
+		aws.ToString(put.SSEKMSKeyId) != b.sseKMSKeyID ||
+		put.StorageClass != b.storageClass ||
+		aws.ToString(put.Tagging) != b.tagging ||
+		put.ACL != b.acl {
+		t.Errorf("unexpected put options: %+v", put)
+	}
+	cp := client.lastCopy
+	if cp.ServerSideEncryption != b.sse ||
+		aws.ToString(cp.SSEKMSKeyId) != b.sseKMSKeyID ||
+		cp.StorageClass != b.storageClass ||
+		aws.ToString(cp.Tagging) != b.tagging ||
+		cp.TaggingDirective != s3types.TaggingDirectiveReplace ||
+		cp.ACL != b.acl {
+		t.Errorf("unexpected copy options: %+v", cp)
+	}
+}
+
+func TestS3SSECustomerKey(t *testing.T) {
+	_, err := newSSECustomerKey("dG90bw==")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	key, err := newSSECustomerKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockS3Client{}
+	b := s3Backend{
+		bucket:      "test-bucket",
+		key:         "/store.scrt",
+		sseCustomer: key,
+		client:      client,
+	}
+
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := md5.Sum(make([]byte, 32))
+	wantMD5 := base64.StdEncoding.EncodeToString(sum[:])
+	requests := []struct {
+		name           string
+		algo, key, md5 *string
+	}{
+		{
+			name: "put",
+			algo: client.lastPut.SSECustomerAlgorithm,
+			key:  client.lastPut.SSECustomerKey,
+			md5:  client.lastPut.SSECustomerKeyMD5,
+		},
+		{
+			name: "get",
+			algo: client.lastGet.SSECustomerAlgorithm,
+			key:  client.lastGet.SSECustomerKey,
+			md5:  client.lastGet.SSECustomerKeyMD5,
+		},
+		{
+			name: "head",
+			algo: client.lastHead.SSECustomerAlgorithm,
+			key:  client.lastHead.SSECustomerKey,
+			md5:  client.lastHead.SSECustomerKeyMD5,
+		},
+	}
+	for _, r := range requests {
+		if aws.ToString(r.algo) != "AES256" ||
+			aws.ToString(r.key) != encoded ||
+			aws.ToString(r.md5) != wantMD5 {
+			t.Errorf("%s: unexpected SSE-C headers", r.name)
+		}
+	}
+}
+
+func TestToS3Tagging(t *testing.T) {
+	testCases := []struct {
+		tags interface{}
+		want string
+	}{
+		{tags: "team=ops", want: "team=ops"},
+		{tags: "team=ops, env=prod", want: "env=prod&team=ops"},
+		{tags: "name=a b&c", want: "name=a+b%26c"},
+		{
+			tags: map[string]interface{}{"team": "ops", "level": 3},
+			want: "level=3&team=ops",
+		},
+	}
+	for _, tc := range testCases {
+		got, err := toS3Tagging(tc.tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%v: expected %q, got %q", tc.tags, tc.want, got)
+		}
+	}
+
+	for _, tags := range []interface{}{"team", "=ops", 3} {
+		_, err := toS3Tagging(tags)
+		if err == nil {
+			t.Errorf("%v: expected error", tags)
+		}
+	}
+}
+
+func TestS3ListStores(t *testing.T) {
+	client := &mockS3Client{}
+	for _, key := range []string{
+		"teams/a/dev.scrt",
+		"teams/a/dev.scrt.1",
+		"teams/a/prod.scrt",
+		"teams/b/",
+		"teams/b/dev.scrt",
+		"other/dev.scrt",
+	} {
+		client.put(key, []byte("data"))
+	}
+
+	b := s3Backend{
+		bucket: "test-bucket",
+		prefix: "teams",
+		client: client,
+	}
+	got, err := b.ListStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a/dev.scrt", "a/prod.scrt", "b/dev.scrt"}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+
+	// Without a key, the store cannot be accessed
+	_, err = b.Exists()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	err = b.Save([]byte("data"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Without a prefix, list next to the key
+	b = s3Backend{
+		bucket: "test-bucket",
+		key:    "teams/a/dev.scrt",
+		client: client,
+	}
+	got, err = b.ListStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"teams/a/dev.scrt", "teams/a/prod.scrt"}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+}
+
+func TestS3Share(t *testing.T) {
+	client := s3.New(s3.Options{
+		Region: "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider(
+			"AKID",
+			"SECRET",
+			"",
+		),
+	})
+	b := s3Backend{
+		bucket:    "test-bucket",
+		key:       "store.scrt",
+		client:    &mockS3Client{},
+		presigner: s3.NewPresignClient(client),
+	}
+
+	rawURL, err := b.Share(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(u.Host+u.Path, "test-bucket") ||
+		!strings.HasSuffix(u.Path, "/store.scrt") {
+		t.Fatalf("unexpected URL: %s", rawURL)
+	}
+	if expires := u.Query().Get("X-Amz-Expires"); expires != "3600" {
+		t.Fatalf("expected expiration 3600, got %s", expires)
+	}
+
+	for _, expires := range []time.Duration{0, -time.Hour, 8 * 24 * time.Hour} {
+		_, err = b.Share(expires)
+		if err == nil {
+			t.Fatalf("expected error for expiration %s", expires)
+		}
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	b.sseCustomer, err = newSSECustomerKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.Share(time.Hour)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestS3Delete(t *testing.T) {
+	client := &mockS3Client{}
+	b := s3Backend{
+		bucket: "test-bucket",
+		key:    "/store.scrt",
+		client: client,
+	}
+
+	err := b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected store not to exist")
+	}
+
+	// The previous versions of the store are kept
+	versions, err := b.ListVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("expected 1 version, got %d", len(versions))
+	}
+
+	err = b.Delete()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/sftp"
+	"github.com/spf13/pflag"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var sftpFlagSet *pflag.FlagSet
+
+func init() {
+	sftpFlagSet = pflag.NewFlagSet("sftp", pflag.ContinueOnError)
+	sftpFlagSet.String(
+		"sftp-host",
+		"",
+		"hostname of the SFTP server (required)",
+	)
+	sftpFlagSet.Int(
+		"sftp-port",
+		0,
+		"port of the SFTP server (default from SSH config, or 22)",
+	)
+	sftpFlagSet.String(
+		"sftp-user",
+		"",
+		"user on the SFTP server (default from SSH config, or current user)",
+	)
+	sftpFlagSet.String(
+		"sftp-path",
+		"",
+		"path to the store on the SFTP server (required)",
+	)
+	sftpFlagSet.String(
+		"sftp-known-hosts",
+		"",
+		"path to the SSH known hosts file (default from SSH config)",
+	)
+	sftpFlagSet.String(
+		"sftp-ssh-key",
+		"",
+		"path to the SSH private key (default identity files from SSH config)",
+	)
+	sftpFlagSet.String(
+		"sftp-ssh-key-passphrase",
+		"",
+		"passphrase of the SSH private keys",
+	)
+	markSecret(sftpFlagSet, "sftp-ssh-key-passphrase")
+}
+
+// posixRenameExtension is the SFTP extension replacing the target of a rename
+// atomically.
+const posixRenameExtension = "posix-rename@openssh.com"
+
+type sftpBackend struct {
+	path      string
+	client    *sftp.Client
+	sshClient *gossh.Client
+}
+
+type sftpFactory struct{}
+
+func (f sftpFactory) New(conf map[string]interface{}) (Backend, error) {
+	return f.NewContext(context.Background(), conf)
+}
+
+func (f sftpFactory) NewContext(
+	ctx context.Context,
+	conf map[string]interface{},
+) (Backend, error) {
+	return newSFTP(ctx, conf)
+}
+
+func (f sftpFactory) Name() string {
+	return "SFTP"
+}
+
+func (f sftpFactory) Description() string {
+	return "store secrets to a remote server over SFTP"
+}
+
+func (f sftpFactory) Flags() *pflag.FlagSet {
+	return sftpFlagSet
+}
+
+func (f sftpFactory) Capabilities() []Capability {
+	return Capabilities(sftpBackend{})
+}
+
+func init() {
+	Backends["sftp"] = sftpFactory{}
+}
+
+func newSFTP(
+	ctx context.Context,
+	conf map[string]interface{},
+) (Backend, error) {
+	logger := getLogger(ctx)
+
+	opt := readOpt("sftp", "host", conf)
+	if opt == nil || opt == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	host, ok := opt.(string)
+	if !ok {
+		return nil, fmt.Errorf("host is not a string: (%T)%s", opt, opt)
+	}
+
+	opt = readOpt("sftp", "path", conf)
+	if opt == nil || opt == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	storePath, ok := opt.(string)
+	if !ok {
+		return nil, fmt.Errorf("path is not a string: (%T)%s", opt, opt)
+	}
+
+	port := 0
+	opt = readOpt("sftp", "port", conf)
+	if opt != nil && opt != 0 {
+		var err error
+		port, err = toInt(opt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %w", err)
+		}
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port: %d", port)
+		}
+	}
+	if port == 0 {
+		port, _ = strconv.Atoi(sshSettings.Get(host, "Port"))
+	}
+	if port == 0 {
+		port = 22
+	}
+
+	var username string
+	opt = readOpt("sftp", "user", conf)
+	if opt != nil && opt != "" {
+		username, ok = opt.(string)
+		if !ok {
+			return nil, fmt.Errorf("user is not a string: (%T)%s", opt, opt)
+		}
+	}
+	if username == "" {
+		username = sshSettings.Get(host, "User")
+	}
+	if username == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("missing user: %w", err)
+		}
+		username = u.Username
+	}
+
+	sshAuth, err := readSSHAuth("sftp", conf)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &transport.Endpoint{
+		Protocol: "ssh",
+		User:     username,
+		Host:     host,
+		Port:     port,
+	}
+	config, err := sshAuth.clientConfig(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+
+	// Host aliases are resolved from the SSH configuration
+	hostname := host
+	if h := sshSettings.Get(host, "HostName"); h != "" {
+		hostname = h
+	}
+	addr := net.JoinHostPort(hostname, strconv.Itoa(port))
+	logger.
+		WithField("address", addr).
+		WithField("user", username).
+		WithField("path", storePath).
+		Info("connecting to SFTP server")
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, addr, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	sshClient := gossh.NewClient(sshConn, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, err
+	}
+
+	return sftpBackend{
+		path:      storePath,
+		client:    client,
+		sshClient: sshClient,
+	}, nil
+}
+
+// Close closes the SFTP session and the SSH connection to the server.
+func (s sftpBackend) Close() error {
+	err := s.client.Close()
+	if sshErr := s.sshClient.Close(); err == nil {
+		err = sshErr
+	}
+	return err
+}
+
+func (s sftpBackend) Exists() (bool, error) {
+	return s.ExistsContext(context.Background())
+}
+
+func (s sftpBackend) ExistsContext(ctx context.Context) (bool, error) {
+	logger := getLogger(ctx)
+	logger.WithField("path", s.path).Info("checking store existence")
+
+	_, err := s.client.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s sftpBackend) Save(data []byte) error {
+	return s.SaveContext(context.Background(), data)
+}
+
+// SaveContext writes data to a temporary file next to the store file, then
+// renames it over the store file. The rename is atomic if the server supports
+// the posix-rename extension.
+func (s sftpBackend) SaveContext(ctx context.Context, data []byte) error {
+	logger := getLogger(ctx)
+	logger.WithField("path", s.path).
+		Info("writing encrypted data to SFTP server")
+
+	tmpPath, err := s.tmpPath("tmp")
+	if err != nil {
+		return err
+	}
+
+	err = s.writeFile(tmpPath, data)
+	if err != nil {
+		_ = s.client.Remove(tmpPath)
+		return err
+	}
+
+	err = s.rename(ctx, tmpPath, s.path)
+	if err != nil {
+		_ = s.client.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// tmpPath returns a random path with the given extension, hidden next to the
+// store file.
+func (s sftpBackend) tmpPath(ext string) (string, error) {
+	suffix := make([]byte, 8)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(
+		path.Dir(s.path),
+		fmt.Sprintf(".%s.%x.%s", path.Base(s.path), suffix, ext),
+	), nil
+}
+
+// writeFile creates the file at p, readable only by its owner, and writes
+// data to it.
+func (s sftpBackend) writeFile(p string, data []byte) error {
+	f, err := s.client.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(0o600)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	n, err := f.Write(data)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if n != len(data) {
+		_ = f.Close()
+		return io.ErrShortWrite
+	}
+	return f.Close()
+}
+
+// rename renames oldPath to newPath, replacing newPath if it exists.
+func (s sftpBackend) rename(
+	ctx context.Context,
+	oldPath, newPath string,
+) error {
+	if _, ok := s.client.HasExtension(posixRenameExtension); ok {
+		return s.client.PosixRename(oldPath, newPath)
+	}
+
+	// Without the extension, the rename fails if newPath exists. Move newPath
+	// aside first, and move it back if the rename fails.
+	logger := getLogger(ctx)
+	logger.Warnf("%s is not supported, replacing store", posixRenameExtension)
+	oldStorePath, err := s.tmpPath("old")
+	if err != nil {
+		return err
+	}
+	err = s.client.Rename(newPath, oldStorePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return s.client.Rename(oldPath, newPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.client.Rename(oldPath, newPath)
+	if err != nil {
+		restoreErr := s.client.Rename(oldStorePath, newPath)
+		if restoreErr != nil {
+			return fmt.Errorf(
+				"%w, previous store left at %s: %s",
+				err,
+				oldStorePath,
+				restoreErr,
+			)
+		}
+		return err
+	}
+
+	err = s.client.Remove(oldStorePath)
+	if err != nil {
+		logger.WithError(err).
+			WithField("path", oldStorePath).
+			Warn("could not remove previous store")
+	}
+	return nil
+}
+
+func (s sftpBackend) Load() ([]byte, error) {
+	return s.LoadContext(context.Background())
+}
+
+func (s sftpBackend) LoadContext(ctx context.Context) ([]byte, error) {
+	logger := getLogger(ctx)
+	logger.WithField("path", s.path).
+		Info("reading encrypted data from SFTP server")
+
+	f, err := s.client.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &NotFoundError{Err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return io.ReadAll(f)
+}
+
+func (s sftpBackend) Delete() error {
+	return s.DeleteContext(context.Background())
+}
+
+func (s sftpBackend) DeleteContext(ctx context.Context) error {
+	logger := getLogger(ctx)
+	logger.WithField("path", s.path).Info("deleting store file")
+
+	err := s.client.Remove(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &NotFoundError{Err: err}
+	}
+	return err
+}
+
+func (s sftpBackend) ListStores() ([]string, error) {
+	return s.ListStoresContext(context.Background())
+}
+
+// ListStoresContext lists the files in the directory of the store, or in the
+// store path itself if it is a directory.
+func (s sftpBackend) ListStoresContext(ctx context.Context) ([]string, error) {
+	dir := path.Dir(s.path)
+	fi, err := s.client.Stat(s.path)
+	if err == nil && fi.IsDir() {
+		dir = s.path
+	}
+
+	logger := getLogger(ctx)
+	logger.WithField("path", dir).Info("listing store files")
+
+	infos, err := s.client.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, path.Join(dir, info.Name()))
+		}
+	}
+	return filterStores(names), nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+)
+
+func TestSFTPFactory(t *testing.T) {
+	f := sftpFactory{}
+
+	testGenericFactory(t, f)
+
+	testCases := []map[string]interface{}{
+		{},
+		{"sftp-path": "/tmp/store.scrt"},
+		{"sftp-host": "localhost"},
+		{
+			"sftp-host": "localhost",
+			"sftp-path": "/tmp/store.scrt",
+			"sftp-port": 70000,
+		},
+		{
+			"sftp-host": "localhost",
+			"sftp-path": "/tmp/store.scrt",
+			"sftp-port": "toto",
+		},
+		{
+			"sftp-host": 12,
+			"sftp-path": "/tmp/store.scrt",
+		},
+	}
+	for i, conf := range testCases {
+		_, err := f.New(conf)
+		if err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newSFTPServer starts an SSH server accepting userKey, serving the SFTP
+// subsystem on the local filesystem. Returns the address of the server and
+// its host key.
+func newSFTPServer(
+	t *testing.T,
+	userKey ssh.PublicKey,
+) (string, ssh.PublicKey) {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(
+			_ ssh.ConnMetadata,
+			key ssh.PublicKey,
+		) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), userKey.Marshal()) {
+				return nil, fmt.Errorf("unauthorized key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	return l.Addr().String(), hostSigner.PublicKey()
+}
+
+// serveSFTP serves the SFTP subsystem for the sessions of an SSH connection.
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		channel, reqs, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer func() { _ = channel.Close() }()
+			for req := range reqs {
+				var payload struct{ Name string }
+				if req.Type != "subsystem" ||
+					ssh.Unmarshal(req.Payload, &payload) != nil ||
+					payload.Name != "sftp" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				_ = server.Serve()
+				return
+			}
+		}()
+	}
+}
+
+// newSFTPConf starts an SFTP server, and returns the configuration of a
+// backend connecting to it with a new key, and the directory of the store.
+func newSFTPConf(t *testing.T) (map[string]interface{}, string) {
+	t.Helper()
+
+	keyPath, userKey := newSSHKey(t, "")
+	addr, hostKey := newSFTPServer(t, userKey)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)
+	err = os.WriteFile(knownHosts, []byte(line+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSSHConfig(t, "Host *\n  IdentitiesOnly yes\n")
+
+	storeDir := filepath.Join(dir, "stores")
+	err = os.Mkdir(storeDir, 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := strconv.Atoi(port)
+	return map[string]interface{}{
+		"sftp-host":        host,
+		"sftp-port":        p,
+		"sftp-user":        "scrt",
+		"sftp-path":        filepath.Join(storeDir, "store.scrt"),
+		"sftp-ssh-key":     keyPath,
+		"sftp-known-hosts": knownHosts,
+	}, storeDir
+}
+
+func closeSFTP(t *testing.T, b Backend) {
+	t.Helper()
+
+	err := b.(sftpBackend).Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSFTPSaveLoad(t *testing.T) {
+	conf, dir := newSFTPConf(t)
+
+	b, err := newSFTP(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSFTP(t, b)
+
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected store not to exist")
+	}
+	_, err = b.Load()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	for _, data := range []string{"v0", "v1"} {
+		err = b.Save([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]byte(data), got) {
+			t.Fatalf("expected %#v, got %#v", []byte(data), got)
+		}
+	}
+
+	exists, err = b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected store to exist")
+	}
+
+	// The temporary files are renamed over the store
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "store.scrt" {
+		t.Fatalf("unexpected files: %v", entries)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "store.scrt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode 0600, got %s", fi.Mode().Perm())
+	}
+}
+
+func TestSFTPListDelete(t *testing.T) {
+	conf, dir := newSFTPConf(t)
+
+	for _, name := range []string{"other.scrt", "store.scrt.lock"} {
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := newSFTP(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSFTP(t, b)
+	err = b.Save([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores, err := b.(Lister).ListStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "other.scrt"),
+		filepath.Join(dir, "store.scrt"),
+	}
+	if !reflect.DeepEqual(want, stores) {
+		t.Fatalf("expected %#v, got %#v", want, stores)
+	}
+
+	err = b.(Deleter).Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected store not to exist")
+	}
+	err = b.(Deleter).Delete()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestSFTPAuth(t *testing.T) {
+	conf, _ := newSFTPConf(t)
+
+	// Host alias, port, user and key from the SSH configuration
+	keyPath := conf["sftp-ssh-key"].(string)
+	setSSHConfig(t, fmt.Sprintf(
+		"Host store\n  HostName %s\n  Port %d\n  User scrt\n"+
+			"  IdentitiesOnly yes\n  IdentityFile %s\n",
+		conf["sftp-host"],
+		conf["sftp-port"],
+		keyPath,
+	))
+	aliasConf := map[string]interface{}{
+		"sftp-host":        "store",
+		"sftp-path":        conf["sftp-path"],
+		"sftp-known-hosts": conf["sftp-known-hosts"],
+	}
+	b, err := newSFTP(context.Background(), aliasConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeSFTP(t, b)
+
+	// Unauthorized key
+	otherKey, _ := newSSHKey(t, "")
+	conf["sftp-ssh-key"] = otherKey
+	_, err = newSFTP(context.Background(), conf)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Unknown host
+	conf["sftp-ssh-key"] = keyPath
+	conf["sftp-known-hosts"] = filepath.Join(t.TempDir(), "known_hosts")
+	_, err = newSFTP(context.Background(), conf)
+	if err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Fatalf("expected unknown host error, got %v", err)
+	}
+}
+
+func TestSFTPNestedOptions(t *testing.T) {
+	flat, _ := newSFTPConf(t)
+
+	conf := readConf(t, sftpFactory{}, fmt.Sprintf(
+		"sftp:\n  host: %s\n  port: %d\n  user: scrt\n  path: %s\n"+
+			"  ssh-key: %s\n  known-hosts: %s\n",
+		flat["sftp-host"],
+		flat["sftp-port"],
+		flat["sftp-path"],
+		flat["sftp-ssh-key"],
+		flat["sftp-known-hosts"],
+	))
+	b, err := newSFTP(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeSFTP(t, b)
+}
@@ -0,0 +1,179 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/pflag"
+)
+
+var sharedFlagSet *pflag.FlagSet
+
+func init() {
+	sharedFlagSet = pflag.NewFlagSet("shared", pflag.ContinueOnError)
+	sharedFlagSet.String(
+		"shared-url",
+		"",
+		"URL of the shared store, given by scrt share (required)",
+	)
+	markSecret(sharedFlagSet, "shared-url")
+}
+
+var errSharedReadOnly = errors.New("shared store is read-only")
+
+// shared reads a store from a URL, e.g. a presigned URL given by the share
+// command. The URL is a credential in itself: it is redacted from the
+// configuration log, and only its host is logged.
+type shared struct {
+	url    *url.URL
+	client *http.Client
+}
+
+type sharedFactory struct{}
+
+func (f sharedFactory) New(conf map[string]interface{}) (Backend, error) {
+	return f.NewContext(context.Background(), conf)
+}
+
+func (f sharedFactory) NewContext(
+	ctx context.Context,
+	conf map[string]interface{},
+) (Backend, error) {
+	return newShared(ctx, conf)
+}
+
+func (f sharedFactory) Name() string {
+	return "Shared"
+}
+
+func (f sharedFactory) Description() string {
+	return "read a store shared by URL (read-only)"
+}
+
+func (f sharedFactory) Flags() *pflag.FlagSet {
+	return sharedFlagSet
+}
+
+func (f sharedFactory) Capabilities() []Capability {
+	return Capabilities(shared{})
+}
+
+func init() {
+	Backends["shared"] = sharedFactory{}
+}
+
+func newShared(
+	ctx context.Context,
+	conf map[string]interface{},
+) (Backend, error) {
+	logger := getLogger(ctx)
+
+	opt := readOpt("shared", "url", conf)
+	if opt == nil || opt == "" {
+		return nil, fmt.Errorf("missing URL")
+	}
+	rawURL, ok := opt.(string)
+	if !ok {
+		return nil, fmt.Errorf("URL is not a string: (%T)%s", opt, opt)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("invalid URL scheme: %s", u.Scheme)
+	}
+	logger.WithField("host", u.Host).Infof("using shared store")
+
+	return shared{url: u, client: http.DefaultClient}, nil
+}
+
+func (s shared) Exists() (bool, error) {
+	return s.ExistsContext(context.Background())
+}
+
+func (s shared) ExistsContext(ctx context.Context) (bool, error) {
+	logger := getLogger(ctx)
+	logger.WithField("host", s.url.Host).Info("checking store existence")
+
+	// Presigned URLs are only valid for GET requests, so HEAD cannot be used
+	_, err := s.LoadContext(ctx)
+	if err != nil {
+		var notFoundErr *NotFoundError
+		if errors.As(err, &notFoundErr) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s shared) Save(data []byte) error {
+	return s.SaveContext(context.Background(), data)
+}
+
+func (s shared) SaveContext(ctx context.Context, data []byte) error {
+	return errSharedReadOnly
+}
+
+func (s shared) Load() ([]byte, error) {
+	return s.LoadContext(context.Background())
+}
+
+func (s shared) LoadContext(ctx context.Context) ([]byte, error) {
+	logger := getLogger(ctx)
+	logger.WithField("host", s.url.Host).
+		Info("reading encrypted data from shared store")
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		s.url.String(),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		// Strip the URL from the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, &NotFoundError{}
+	case res.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf(
+			"access denied, the URL may have expired: %s",
+			res.Status,
+		)
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return nil, fmt.Errorf("unexpected response: %s", res.Status)
+	}
+
+	return io.ReadAll(res.Body)
+}
@@ -0,0 +1,107 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestShared(t *testing.T, handler http.HandlerFunc) shared {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL + "/store.scrt?X-Amz-Signature=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return shared{url: u, client: server.Client()}
+}
+
+func TestSharedLoad(t *testing.T) {
+	data := []byte("encrypted")
+	b := newTestShared(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("expected GET, got %s", r.Method)
+		}
+		if r.URL.Query().Get("X-Amz-Signature") != "secret" {
+			t.Errorf("missing query in %s", r.URL)
+		}
+		_, _ = w.Write(data)
+	})
+
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("expected store to exist")
+	}
+
+	got, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Fatalf("expected %q, got %q", data, got)
+	}
+
+	err = b.Save(data)
+	if !errors.Is(err, errSharedReadOnly) {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+}
+
+func TestSharedLoadErrors(t *testing.T) {
+	b := newTestShared(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	exists, err := b.Exists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("expected store not to exist")
+	}
+	_, err = b.Load()
+	var notFoundErr *NotFoundError
+	if !errors.As(err, &notFoundErr) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	b = newTestShared(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	_, err = b.Exists()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	_, err = b.Load()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("URL leaked in error: %s", err)
+	}
+}
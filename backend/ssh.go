@@ -0,0 +1,430 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/kevinburke/ssh_config"
+	homedir "github.com/mitchellh/go-homedir"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// sshSettings reads the SSH configuration files.
+var sshSettings = ssh_config.DefaultUserSettings
+
+// sshAuth holds the options of the backends connecting to SSH servers.
+type sshAuth struct {
+	// knownHosts is the path of the known hosts file, overriding the SSH
+	// configuration
+	knownHosts string
+	// key is the path of the private key, overriding the SSH configuration
+	key string
+	// keyPass is the passphrase of the private keys
+	keyPass string
+}
+
+// readSSHAuth reads the SSH options of the backend named prefix.
+func readSSHAuth(prefix string, conf map[string]interface{}) (sshAuth, error) {
+	var a sshAuth
+	var ok bool
+	var err error
+
+	opt := readOpt(prefix, "known-hosts", conf)
+	if opt != nil && opt != "" {
+		a.knownHosts, ok = opt.(string)
+		if !ok {
+			return a, fmt.Errorf(
+				"known hosts file is not a string: (%T)%s",
+				opt,
+				opt,
+			)
+		}
+		a.knownHosts, err = homedir.Expand(a.knownHosts)
+		if err != nil {
+			return a, err
+		}
+	}
+
+	opt = readOpt(prefix, "ssh-key", conf)
+	if opt != nil && opt != "" {
+		a.key, ok = opt.(string)
+		if !ok {
+			return a, fmt.Errorf("SSH key is not a string: (%T)%s", opt, opt)
+		}
+		a.key, err = homedir.Expand(a.key)
+		if err != nil {
+			return a, err
+		}
+	}
+	opt = readOpt(prefix, "ssh-key-passphrase", conf)
+	if opt != nil && opt != "" {
+		a.keyPass, ok = opt.(string)
+		if !ok {
+			return a, fmt.Errorf(
+				"SSH key passphrase is not a string: (%T)",
+				opt,
+			)
+		}
+	}
+
+	return a, nil
+}
+
+// hostKeyCallback returns the callback verifying the host key of the SSH
+// server at e, and the host key algorithms to negotiate with the server.
+// Host keys are verified against the known hosts files, following the
+// StrictHostKeyChecking option of the SSH configuration:
+//   - "yes" or "ask" (default): unknown hosts and changed keys are rejected
+//   - "accept-new": unknown hosts are added to the known hosts file, changed
+//     keys are rejected
+//   - "no" or "off": unknown hosts are added to the known hosts file, changed
+//     keys are accepted
+func (a sshAuth) hostKeyCallback(
+	ctx context.Context,
+	e *transport.Endpoint,
+) (gossh.HostKeyCallback, []string, error) {
+	logger := getLogger(ctx)
+
+	var userFiles, globalFiles []string
+	if a.knownHosts != "" {
+		userFiles = []string{a.knownHosts}
+	} else {
+		userFiles = strings.Fields(
+			sshSettings.Get(e.Host, "UserKnownHostsFile"),
+		)
+		globalFiles = strings.Fields(
+			sshSettings.Get(e.Host, "GlobalKnownHostsFile"),
+		)
+	}
+	strict := strings.ToLower(
+		sshSettings.Get(e.Host, "StrictHostKeyChecking"),
+	)
+
+	var files []string
+	for i, f := range userFiles {
+		f, err := homedir.Expand(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		userFiles[i] = f
+		if fileExists(f) {
+			files = append(files, f)
+		}
+	}
+	for _, f := range globalFiles {
+		if fileExists(f) {
+			files = append(files, f)
+		}
+	}
+	logger.
+		WithField("known_hosts", files).
+		WithField("strict_host_key_checking", strict).
+		Info("configuring SSH host key verification")
+
+	// Without known hosts files, every host is unknown
+	verify := func(string, net.Addr, gossh.PublicKey) error {
+		return &knownhosts.KeyError{}
+	}
+	var hostKeyAlgorithms []string
+	if len(files) > 0 {
+		db, err := ssh.NewKnownHostsDb(files...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read known hosts: %w", err)
+		}
+		verify = db.HostKeyCallback()
+		port := e.Port
+		if port == 0 {
+			port = 22
+		}
+		hostKeyAlgorithms = db.HostKeyAlgorithms(
+			net.JoinHostPort(e.Host, strconv.Itoa(port)),
+		)
+	}
+
+	callback := func(
+		hostname string,
+		remote net.Addr,
+		key gossh.PublicKey,
+	) error {
+		err := verify(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		logger := logger.
+			WithField("host", hostname).
+			WithField("fingerprint", gossh.FingerprintSHA256(key))
+
+		if len(keyErr.Want) > 0 {
+			if strict == "no" || strict == "off" {
+				logger.Warn("SSH host key has changed")
+				return nil
+			}
+			return fmt.Errorf(
+				"host key for %s has changed, "+
+					"it may have been spoofed: %s",
+				hostname,
+				gossh.FingerprintSHA256(key),
+			)
+		}
+
+		if strict != "accept-new" && strict != "no" && strict != "off" {
+			return fmt.Errorf(
+				"host key for %s is unknown, "+
+					"add it to the known hosts file: %s",
+				hostname,
+				gossh.FingerprintSHA256(key),
+			)
+		}
+		if len(userFiles) == 0 {
+			return fmt.Errorf("no known hosts file to add %s", hostname)
+		}
+		logger.
+			WithField("known_hosts", userFiles[0]).
+			Info("adding new SSH host key to known hosts")
+		return addKnownHost(userFiles[0], hostname, key)
+	}
+
+	return callback, hostKeyAlgorithms, nil
+}
+
+// authMethods returns the authentication methods to the SSH server at e: the
+// key from the options, or the SSH agent and the identity files from the SSH
+// configuration.
+func (a sshAuth) authMethods(
+	ctx context.Context,
+	e *transport.Endpoint,
+) ([]transport.AuthMethod, error) {
+	logger := getLogger(ctx)
+
+	logger.Info("configuring SSH authentication methods")
+
+	if a.key != "" {
+		logger.
+			WithField("identity_file", a.key).
+			Info("using SSH key from options")
+		auth, err := loadSSHKey(e.User, a.key, a.keyPass)
+		if err != nil {
+			return nil, fmt.Errorf("could not load SSH key: %w", err)
+		}
+		return []transport.AuthMethod{auth}, nil
+	}
+
+	auths := make([]transport.AuthMethod, 0, 2)
+
+	identitiesOnly := sshSettings.Get(e.Host, "IdentitiesOnly")
+	if identitiesOnly != "yes" {
+		logger.Info("using SSH agent authentication (if available)")
+		sshAgentAuth, err := ssh.NewSSHAgentAuth(e.User)
+		if err == nil {
+			auths = append(auths, sshAgentAuth)
+		}
+	} else {
+		logger.Info("using identity files only")
+	}
+
+	idFiles := sshSettings.GetAll(e.Host, "IdentityFile")
+	for _, idFile := range idFiles {
+		idFile, err := homedir.Expand(idFile)
+		if err != nil {
+			continue
+		}
+		logger := logger.WithField("identity_file", idFile)
+		publicKeyAuth, err := loadSSHKey(e.User, idFile, a.keyPass)
+		if err == nil {
+			logger.Info("identity file found")
+			auths = append(auths, publicKeyAuth)
+		} else {
+			logger.WithError(err).Info("could not load identity file")
+		}
+	}
+
+	if len(auths) > 0 {
+		return auths, nil
+	}
+
+	return nil, fmt.Errorf("no valid authentication method")
+}
+
+// clientConfig returns the configuration of an SSH client connecting to the
+// server at e, with the authentication methods and the host key verification
+// of the options and the SSH configuration.
+func (a sshAuth) clientConfig(
+	ctx context.Context,
+	e *transport.Endpoint,
+) (*gossh.ClientConfig, error) {
+	hostKeyCallback, hostKeyAlgorithms, err := a.hostKeyCallback(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	auths, err := a.authMethods(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return &gossh.ClientConfig{
+		User:              e.User,
+		Auth:              []gossh.AuthMethod{publicKeysAuth(auths)},
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+	}, nil
+}
+
+// publicKeysAuth merges the keys of auths in a single authentication method,
+// since the SSH client only tries the first method of each type.
+func publicKeysAuth(auths []transport.AuthMethod) gossh.AuthMethod {
+	return gossh.PublicKeysCallback(func() ([]gossh.Signer, error) {
+		var signers []gossh.Signer
+		var lastErr error
+		for _, auth := range auths {
+			switch a := auth.(type) {
+			case *ssh.PublicKeys:
+				signers = append(signers, a.Signer)
+			case *ssh.PublicKeysCallback:
+				s, err := a.Callback()
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				signers = append(signers, s...)
+			}
+		}
+		if len(signers) == 0 && lastErr != nil {
+			return nil, lastErr
+		}
+		return signers, nil
+	})
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// addKnownHost appends the key of hostname to the known hosts file at path.
+func addKnownHost(path, hostname string, key gossh.PublicKey) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	_, err = fmt.Fprintln(f, line)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// setHostKeyCallback configures the host key verification of SSH
+// authentication methods.
+func setHostKeyCallback(
+	auths []transport.AuthMethod,
+	callback gossh.HostKeyCallback,
+	algorithms []string,
+) {
+	helper := ssh.HostKeyCallbackHelper{
+		HostKeyCallback:   callback,
+		HostKeyAlgorithms: algorithms,
+	}
+	for _, auth := range auths {
+		switch a := auth.(type) {
+		case *ssh.PublicKeys:
+			a.HostKeyCallbackHelper = helper
+		case *ssh.PublicKeysCallback:
+			a.HostKeyCallbackHelper = helper
+		}
+	}
+}
+
+// loadSSHKey returns an authentication method with the private key at path,
+// decrypted with passphrase. If the key is encrypted and passphrase is empty,
+// the passphrase is read from the terminal when the key is first used.
+func loadSSHKey(user, path, passphrase string) (transport.AuthMethod, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = gossh.ParsePrivateKey(pemBytes)
+	var missingErr *gossh.PassphraseMissingError
+	if errors.As(err, &missingErr) && passphrase == "" {
+		return encryptedKeyAuth(user, path, pemBytes), nil
+	}
+
+	auth, err := ssh.NewPublicKeys(user, pemBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// encryptedKeyAuth returns an authentication method with the encrypted
+// private key in pemBytes. The passphrase is read from the terminal the first
+// time the key is used.
+func encryptedKeyAuth(user, path string, pemBytes []byte) transport.AuthMethod {
+	var signer gossh.Signer
+	return &ssh.PublicKeysCallback{
+		User: user,
+		Callback: func() ([]gossh.Signer, error) {
+			if signer == nil {
+				passphrase, err := readPassphrase(
+					fmt.Sprintf("Enter passphrase for key '%s': ", path),
+				)
+				if err != nil {
+					return nil, fmt.Errorf(
+						"could not read passphrase for %s: %w",
+						path,
+						err,
+					)
+				}
+				signer, err = gossh.ParsePrivateKeyWithPassphrase(
+					pemBytes,
+					passphrase,
+				)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return []gossh.Signer{signer}, nil
+		},
+	}
+}
+
+// readPassphrase prompts for a passphrase on the terminal.
+var readPassphrase = func(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("standard input is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	return term.ReadPassword(fd)
+}
@@ -0,0 +1,160 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+	"github.com/loderunner/scrt/store"
+)
+
+var backupsCmd = &cobra.Command{
+	Use:   "backups",
+	Short: "List and restore backups of a store",
+	Long: "List and restore backups of a store. Backups are kept when the" +
+		" storage is\nconfigured to keep backups, e.g. with --local-backups.",
+}
+
+var backupsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the backups of a store",
+	Args: func(cmd *cobra.Command, args []string) error {
+		err := cobra.ExactArgs(0)(cmd, args)
+		if err != nil {
+			return err
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage := viper.GetString(configKeyStorage)
+
+		b, err := backend.Backends[storage].NewContext(
+			cmdContext,
+			viper.AllSettings(),
+		)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		k, ok := b.(backend.BackupKeeper)
+		if !ok {
+			return fmt.Errorf("%s storage does not keep backups", storage)
+		}
+
+		backups, err := k.ListBackupsContext(cmdContext)
+		if err != nil {
+			return fmt.Errorf("could not list backups: %w", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, backup := range backups {
+			_, _ = fmt.Fprintf(
+				w,
+				"%d\t%s\t%d bytes\n",
+				backup.Index,
+				backup.ModTime.Format(time.RFC3339),
+				backup.Size,
+			)
+		}
+		return w.Flush()
+	},
+}
+
+var backupsRestoreCmd = &cobra.Command{
+	Use:   "restore [flags] n",
+	Short: "Restore a store from a backup",
+	Long: "Restore a store from the n-th most recent backup. The current" +
+		" store is kept as\nthe most recent backup.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		err := cobra.ExactArgs(1)(cmd, args)
+		if err != nil {
+			return err
+		}
+		_, err = strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid backup: %s", args[0])
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage := viper.GetString(configKeyStorage)
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid backup: %s", args[0])
+		}
+
+		b, err := backend.Backends[storage].NewContext(
+			cmdContext,
+			viper.AllSettings(),
+		)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		k, ok := b.(backend.BackupKeeper)
+		if !ok {
+			return fmt.Errorf("%s storage does not keep backups", storage)
+		}
+
+		unlock, err := lockStore(b)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		data, err := k.LoadBackupContext(cmdContext, n)
+		if err != nil {
+			return fmt.Errorf("could not load backup: %w", err)
+		}
+
+		// Check that the backup can be read with the password before
+		// restoring
+		password := []byte(viper.GetString(configKeyPassword))
+		_, err = store.ReadStoreContext(cmdContext, password, data)
+		if err != nil {
+			return fmt.Errorf("could not read store from backup: %w", err)
+		}
+
+		ctx := backend.NewChangeContext(
+			cmdContext,
+			backend.Change{Operation: "restore"},
+		)
+		err = b.SaveContext(ctx, data)
+		if err != nil {
+			return fmt.Errorf("could not save data to store: %w", err)
+		}
+
+		fmt.Printf("store restored from backup %d\n", n)
+
+		return nil
+	},
+}
+
+func init() {
+	backupsCmd.AddCommand(backupsListCmd)
+	backupsCmd.AddCommand(backupsRestoreCmd)
+	backupsListCmd.FParseErrWhitelist.UnknownFlags = true
+	backupsRestoreCmd.FParseErrWhitelist.UnknownFlags = true
+}
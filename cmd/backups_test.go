@@ -0,0 +1,174 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+	"github.com/loderunner/scrt/store"
+)
+
+type mockBackupBackend struct {
+	*MockBackend
+	*MockBackupKeeper
+}
+
+func TestBackupsListCmd(t *testing.T) {
+	hijack()
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockBackupBackend{
+		MockBackend:      NewMockBackend(ctrl),
+		MockBackupKeeper: NewMockBackupKeeper(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	backups := []backend.Backup{
+		{Index: 1, ModTime: time.Now(), Size: 56},
+		{Index: 2, ModTime: time.Now(), Size: 46},
+	}
+	mockBackend.MockBackupKeeper.EXPECT().
+		ListBackupsContext(ctxMatcher).
+		Return(backups, nil)
+
+	err := backupsListCmd.RunE(backupsListCmd, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Stdout.Close()
+	data, err := io.ReadAll(hijackStdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, fmt.Sprintf("%d ", i+1)) {
+			t.Errorf("unexpected line: %s", line)
+		}
+	}
+}
+
+func TestBackupsCmdUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := NewMockBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	err := backupsListCmd.RunE(backupsListCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	err = backupsRestoreCmd.RunE(backupsRestoreCmd, []string{"1"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBackupsRestoreCmd(t *testing.T) {
+	hijack()
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockBackupBackend{
+		MockBackend:      NewMockBackend(ctrl),
+		MockBackupKeeper: NewMockBackupKeeper(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	password := "toto"
+
+	viper.Reset()
+	viper.Set(configKeyPassword, password)
+	viper.Set(configKeyStorage, "mock")
+
+	s := store.NewStore()
+	data, err := store.WriteStore([]byte(password), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockBackend.MockBackupKeeper.EXPECT().
+		LoadBackupContext(ctxMatcher, 2).
+		Return(data, nil)
+	mockBackend.MockBackend.EXPECT().SaveContext(ctxMatcher, data)
+
+	args := []string{"2"}
+	err = backupsRestoreCmd.Args(backupsRestoreCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backupsRestoreCmd.RunE(backupsRestoreCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupsRestoreCmdInvalidData(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockBackupBackend{
+		MockBackend:      NewMockBackend(ctrl),
+		MockBackupKeeper: NewMockBackupKeeper(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	mockBackend.MockBackupKeeper.EXPECT().
+		LoadBackupContext(ctxMatcher, 1).
+		Return([]byte("toto"), nil)
+
+	err := backupsRestoreCmd.Args(backupsRestoreCmd, []string{"toto"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	err = backupsRestoreCmd.RunE(backupsRestoreCmd, []string{"1"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+	"github.com/loderunner/scrt/store"
+)
+
+var destroyCmd = &cobra.Command{
+	Use:   "destroy [flags]",
+	Short: "Delete a store",
+	Long: "Delete a store from the storage. The password is checked before" +
+		" deleting the store.\nUnless --yes is given, destroy asks for" +
+		" confirmation.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		err := cobra.ExactArgs(0)(cmd, args)
+		if err != nil {
+			return err
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage := viper.GetString(configKeyStorage)
+
+		yes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			return err
+		}
+
+		b, err := backend.Backends[storage].NewContext(
+			cmdContext,
+			viper.AllSettings(),
+		)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		d, ok := b.(backend.Deleter)
+		if !ok {
+			return fmt.Errorf("%s storage cannot delete stores", storage)
+		}
+
+		unlock, err := lockStore(b)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		data, err := b.LoadContext(cmdContext)
+		var notFoundErr *backend.NotFoundError
+		if errors.As(err, &notFoundErr) {
+			return fmt.Errorf("store does not exist")
+		}
+		if err != nil {
+			return fmt.Errorf("could not load data from store: %w", err)
+		}
+
+		// Check the password before deleting, so that a store cannot be
+		// deleted by mistake with the configuration of another store
+		password := []byte(viper.GetString(configKeyPassword))
+		_, err = store.ReadStoreContext(cmdContext, password, data)
+		if err != nil {
+			return fmt.Errorf("could not read store: %w", err)
+		}
+
+		if !yes {
+			if !confirm("Destroy the store? [y/N] ") {
+				return fmt.Errorf("store was not destroyed")
+			}
+		}
+
+		ctx := backend.NewChangeContext(
+			cmdContext,
+			backend.Change{Operation: "destroy"},
+		)
+		err = d.DeleteContext(ctx)
+		if err != nil {
+			return fmt.Errorf("could not delete store: %w", err)
+		}
+
+		fmt.Println("store destroyed")
+
+		return nil
+	},
+}
+
+// confirm prints prompt and reads the answer from stdin. Returns true if the
+// answer is yes.
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		// No answer, e.g. stdin is closed
+		fmt.Println()
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	destroyCmd.Flags().
+		BoolP("yes", "y", false, "destroy the store without confirmation")
+}
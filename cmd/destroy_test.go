@@ -0,0 +1,155 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
+
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+	"github.com/loderunner/scrt/store"
+)
+
+type mockDeleterBackend struct {
+	*MockBackend
+	*MockDeleter
+}
+
+func newMockDeleterBackend(
+	t *testing.T,
+	ctrl *gomock.Controller,
+) mockDeleterBackend {
+	mockBackend := mockDeleterBackend{
+		MockBackend: NewMockBackend(ctrl),
+		MockDeleter: NewMockDeleter(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	data, err := store.WriteStore([]byte("toto"), store.NewStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockBackend.MockBackend.EXPECT().
+		LoadContext(ctxMatcher).
+		Return(data, nil)
+
+	return mockBackend
+}
+
+func TestDestroyCmd(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := newMockDeleterBackend(t, ctrl)
+	setFlag(t, destroyCmd, "yes", "true")
+
+	mockBackend.MockDeleter.EXPECT().
+		DeleteContext(ctxMatcher).
+		DoAndReturn(func(ctx context.Context) error {
+			change, ok := backend.ChangeFromContext(ctx)
+			if !ok || change.Operation != "destroy" {
+				t.Errorf("unexpected change: %#v", change)
+			}
+			return nil
+		})
+
+	err := destroyCmd.RunE(destroyCmd, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDestroyCmdConfirm(t *testing.T) {
+	testCases := []struct {
+		answer string
+		delete bool
+	}{
+		{answer: "y\n", delete: true},
+		{answer: "YES\n", delete: true},
+		{answer: "n\n", delete: false},
+		{answer: "\n", delete: false},
+		{answer: "", delete: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.answer, func(t *testing.T) {
+			hijack()
+			defer restore()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockBackend := newMockDeleterBackend(t, ctrl)
+			if tc.delete {
+				mockBackend.MockDeleter.EXPECT().DeleteContext(ctxMatcher)
+			}
+
+			_, err := hijackStdin.WriteString(tc.answer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = hijackStdin.Close()
+
+			err = destroyCmd.RunE(destroyCmd, []string{})
+			if tc.delete && err != nil {
+				t.Fatal(err)
+			}
+			if !tc.delete && err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestDestroyCmdWrongPassword(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newMockDeleterBackend(t, ctrl)
+	viper.Set(configKeyPassword, "titi")
+	setFlag(t, destroyCmd, "yes", "true")
+
+	err := destroyCmd.RunE(destroyCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDestroyCmdUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := NewMockBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+	setFlag(t, destroyCmd, "yes", "true")
+
+	err := destroyCmd.RunE(destroyCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -15,8 +15,10 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	isatty "github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
@@ -47,18 +49,23 @@ var getCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		defer closeBackend(b)
 
-		exists, err := b.ExistsContext(cmdContext)
-		if err != nil {
-			return fmt.Errorf("could not check store existence: %w", err)
-		}
-		if !exists {
-			return fmt.Errorf("store does not exist")
-		}
-
-		data, err := b.LoadContext(cmdContext)
-		if err != nil {
-			return fmt.Errorf("could not load data: %w", err)
+		var data []byte
+		if cmd.Flags().Changed("at") || cmd.Flags().Changed("revision") {
+			data, err = loadVersion(cmd, b, storage, "revision")
+			if err != nil {
+				return err
+			}
+		} else {
+			data, err = b.LoadContext(cmdContext)
+			var notFoundErr *backend.NotFoundError
+			if errors.As(err, &notFoundErr) {
+				return fmt.Errorf("store does not exist")
+			}
+			if err != nil {
+				return fmt.Errorf("could not load data: %w", err)
+			}
 		}
 
 		password := []byte(viper.GetString(configKeyPassword))
@@ -86,3 +93,70 @@ var getCmd = &cobra.Command{
 		return nil
 	},
 }
+
+// loadVersion loads the data of the store at the version given by the
+// versionFlag flag, or at the time given by the --at flag.
+func loadVersion(
+	cmd *cobra.Command,
+	b backend.Backend,
+	storage string,
+	versionFlag string,
+) ([]byte, error) {
+	v, ok := b.(backend.Versioned)
+	if !ok {
+		return nil, fmt.Errorf("%s storage does not keep versions", storage)
+	}
+
+	if cmd.Flags().Changed(versionFlag) {
+		version, err := cmd.Flags().GetString(versionFlag)
+		if err != nil {
+			return nil, err
+		}
+		data, err := v.LoadVersionContext(cmdContext, version)
+		if err != nil {
+			return nil, fmt.Errorf("could not load data: %w", err)
+		}
+		return data, nil
+	}
+
+	at, err := cmd.Flags().GetString("at")
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseTime(at)
+	if err != nil {
+		return nil, err
+	}
+	data, err := v.LoadAtContext(cmdContext, t)
+	if err != nil {
+		return nil, fmt.Errorf("could not load data: %w", err)
+	}
+	return data, nil
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTime parses a date, with an optional time, in the local time zone
+// unless a time zone is given.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
+
+func init() {
+	getCmd.Flags().
+		String("at", "", "read the store as it was at a date or time")
+	getCmd.Flags().
+		String("revision", "", "read the store at a version (e.g. git commit)")
+	getCmd.MarkFlagsMutuallyExclusive("at", "revision")
+}
@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
 
 package cmd
 
@@ -21,7 +21,9 @@ import (
 	"io"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/spf13/viper"
@@ -57,7 +59,6 @@ func TestGetCmd(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
 	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
 
 	args := []string{"hello"}
@@ -90,7 +91,9 @@ func TestGetCmdNotExists(t *testing.T) {
 	viper.Reset()
 	viper.Set(configKeyStorage, "mock")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(false, nil)
+	mockBackend.EXPECT().
+		LoadContext(ctxMatcher).
+		Return(nil, &backend.NotFoundError{})
 
 	args := []string{"hello"}
 	err := getCmd.Args(getCmd, args)
@@ -113,7 +116,6 @@ func TestGetCmdFailedLoad(t *testing.T) {
 	viper.Reset()
 	viper.Set(configKeyStorage, "mock")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
 	mockBackend.EXPECT().
 		LoadContext(ctxMatcher).
 		Return(nil, fmt.Errorf("error"))
@@ -141,7 +143,6 @@ func TestGetCmdFailedInvalidData(t *testing.T) {
 
 	data := []byte("toto")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
 	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
 
 	args := []string{"hello"}
@@ -174,7 +175,6 @@ func TestGetCmdFailedNoValue(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
 	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
 
 	args := []string{"hello"}
@@ -187,3 +187,155 @@ func TestGetCmdFailedNoValue(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+type mockVersionedBackend struct {
+	*MockBackend
+	*MockVersioned
+}
+
+func TestGetCmdRevision(t *testing.T) {
+	hijack()
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockVersionedBackend{
+		MockBackend:   NewMockBackend(ctrl),
+		MockVersioned: NewMockVersioned(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	password := "toto"
+
+	viper.Reset()
+	viper.Set(configKeyPassword, password)
+	viper.Set(configKeyStorage, "mock")
+
+	s := store.NewStore()
+	testVal := []byte("world")
+	err := s.Set("hello", testVal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.WriteStore([]byte(password), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, getCmd, "revision", "HEAD~3")
+	mockBackend.MockVersioned.EXPECT().
+		LoadVersionContext(ctxMatcher, "HEAD~3").
+		Return(data, nil)
+
+	args := []string{"hello"}
+	err = getCmd.RunE(getCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Stdout.Close()
+	data, err = io.ReadAll(hijackStdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, testVal) {
+		t.Fatalf("expected %#v, got %#v", testVal, data)
+	}
+}
+
+func TestGetCmdAt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockVersionedBackend{
+		MockBackend:   NewMockBackend(ctrl),
+		MockVersioned: NewMockVersioned(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyStorage, "mock")
+
+	at := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	setFlag(t, getCmd, "at", "2024-06-01")
+	mockBackend.MockVersioned.EXPECT().
+		LoadAtContext(ctxMatcher, at).
+		Return(nil, fmt.Errorf("error"))
+
+	err := getCmd.RunE(getCmd, []string{"hello"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGetCmdAtInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockVersionedBackend{
+		MockBackend:   NewMockBackend(ctrl),
+		MockVersioned: NewMockVersioned(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyStorage, "mock")
+
+	setFlag(t, getCmd, "at", "yesterday")
+
+	err := getCmd.RunE(getCmd, []string{"hello"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGetCmdNotVersioned(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := NewMockBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyStorage, "mock")
+
+	setFlag(t, getCmd, "revision", "HEAD")
+
+	err := getCmd.RunE(getCmd, []string{"hello"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "does not keep versions") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{
+			s:    "2024-06-01",
+			want: time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local),
+		},
+		{
+			s:    "2024-06-01 12:30:00",
+			want: time.Date(2024, 6, 1, 12, 30, 0, 0, time.Local),
+		},
+		{
+			s:    "2024-06-01T12:30:00Z",
+			want: time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseTime(%q): expected %s, got %s", tt.s, tt.want, got)
+		}
+	}
+}
@@ -37,6 +37,13 @@ var initCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		defer closeBackend(b)
+
+		unlock, err := lockStore(b)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 
 		exists, err := b.ExistsContext(cmdContext)
 		if err != nil {
@@ -63,7 +70,11 @@ var initCmd = &cobra.Command{
 			return fmt.Errorf("could not write store to data: %w", err)
 		}
 
-		err = b.SaveContext(cmdContext, data)
+		ctx := backend.NewChangeContext(
+			cmdContext,
+			backend.Change{Operation: "init"},
+		)
+		err = b.SaveContext(ctx, data)
 		if err != nil {
 			return fmt.Errorf("could not save data to store: %w", err)
 		}
@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
 
 package cmd
 
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
@@ -44,16 +45,13 @@ var listCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		defer closeBackend(b)
 
-		exists, err := b.ExistsContext(cmdContext)
-		if err != nil {
-			return fmt.Errorf("could not check store existence: %w", err)
-		}
-		if !exists {
+		data, err := b.LoadContext(cmdContext)
+		var notFoundErr *backend.NotFoundError
+		if errors.As(err, &notFoundErr) {
 			return fmt.Errorf("store does not exist")
 		}
-
-		data, err := b.LoadContext(cmdContext)
 		if err != nil {
 			return fmt.Errorf("could not load data from store: %w", err)
 		}
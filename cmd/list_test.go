@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
 
 package cmd
 
@@ -52,7 +52,6 @@ func TestListCmdEmpty(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
 	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
 
 	err = listCmd.RunE(listCmd, []string{})
@@ -97,7 +96,6 @@ func TestListCmd(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
 	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
 
 	args := []string{"hello", "world"}
@@ -131,7 +129,6 @@ func TestListCmdFailedLoad(t *testing.T) {
 	viper.Reset()
 	viper.Set(configKeyStorage, "mock")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
 	mockBackend.EXPECT().
 		LoadContext(ctxMatcher).
 		Return(nil, fmt.Errorf("error"))
@@ -154,7 +151,6 @@ func TestListCmdFailedInvalidData(t *testing.T) {
 
 	data := []byte("toto")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
 	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
 
 	err := listCmd.RunE(listCmd, []string{})
@@ -16,8 +16,14 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 
 	"github.com/apex/log"
+
+	"github.com/loderunner/scrt/backend"
+	"github.com/loderunner/scrt/store"
 )
 
 const (
@@ -25,7 +31,152 @@ const (
 	configKeyStorage  = "storage"
 )
 
+// maxConflictRetries is the number of times an update is retried when the
+// store was modified concurrently.
+const maxConflictRetries = 5
+
 var (
 	cmdContext = context.Background()
 	logger     log.Interface
 )
+
+// lockStore locks the store for exclusive access, if the backend supports
+// locking, and returns a function releasing the lock.
+func lockStore(b backend.Backend) (func(), error) {
+	locker, ok := b.(backend.Locker)
+	if !ok {
+		return func() {}, nil
+	}
+
+	unlock, err := locker.LockContext(cmdContext)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock store: %w", err)
+	}
+
+	return func() {
+		err := unlock()
+		if err != nil {
+			logger.WithError(err).Warn("could not unlock store")
+		}
+	}, nil
+}
+
+// closeBackend releases the resources held by b, e.g. connections or locks, if
+// it implements io.Closer.
+func closeBackend(b backend.Backend) {
+	c, ok := b.(io.Closer)
+	if !ok {
+		return
+	}
+	err := c.Close()
+	if err != nil {
+		logger.WithError(err).Warn("could not close storage")
+	}
+}
+
+// updateStore loads the store from b, applies update to the store and saves it
+// back to b. If the store was modified concurrently, the store is reloaded and
+// the update is applied again, unless the concurrent modification changed the
+// same keys as update. Concurrent modifications are only detected if b is a
+// backend.ConditionalSaver. change describes the update to the backend.
+func updateStore(
+	b backend.Backend,
+	password []byte,
+	change backend.Change,
+	update func(s store.Store) error,
+) error {
+	ctx := backend.NewChangeContext(cmdContext, change)
+
+	// The store loaded by the last attempt, before update, and the keys
+	// changed by update
+	var base store.Store
+	var changed []string
+
+	for i := 0; ; i++ {
+		data, rev, err := loadRevision(b)
+		if err != nil {
+			return fmt.Errorf("could not load data from store: %w", err)
+		}
+
+		s, err := store.ReadStoreContext(cmdContext, password, data)
+		if err != nil {
+			return fmt.Errorf("could not read store from data: %w", err)
+		}
+
+		if i > 0 {
+			key, ok := commonKey(changed, base.DiffContext(cmdContext, s))
+			if ok {
+				return &backend.ConflictError{
+					Err: fmt.Errorf("key \"%s\" was also modified", key),
+				}
+			}
+		}
+
+		err = update(s)
+		if err != nil {
+			return err
+		}
+
+		newData, err := store.WriteStoreContext(cmdContext, password, s)
+		if err != nil {
+			return fmt.Errorf("could not write store to data: %w", err)
+		}
+
+		err = saveRevision(ctx, b, newData, rev)
+		var conflictErr *backend.ConflictError
+		if errors.As(err, &conflictErr) && i < maxConflictRetries {
+			logger.
+				WithField("attempt", i+1).
+				Info("store was modified concurrently, retrying")
+			base, err = store.ReadStoreContext(cmdContext, password, data)
+			if err != nil {
+				return fmt.Errorf("could not read store from data: %w", err)
+			}
+			changed = base.DiffContext(cmdContext, s)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not save data to store: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// loadRevision loads the store from b, along with its revision if b is a
+// backend.ConditionalSaver.
+func loadRevision(b backend.Backend) ([]byte, backend.Revision, error) {
+	cs, ok := b.(backend.ConditionalSaver)
+	if !ok {
+		data, err := b.LoadContext(cmdContext)
+		return data, "", err
+	}
+	return cs.LoadRevisionContext(cmdContext)
+}
+
+// saveRevision saves data to b, only if the store is still at revision rev if
+// b is a backend.ConditionalSaver. Otherwise, the store is overwritten.
+func saveRevision(
+	ctx context.Context,
+	b backend.Backend,
+	data []byte,
+	rev backend.Revision,
+) error {
+	cs, ok := b.(backend.ConditionalSaver)
+	if !ok {
+		return b.SaveContext(ctx, data)
+	}
+	return cs.SaveRevisionContext(ctx, data, rev)
+}
+
+// commonKey returns a key that is in both a and b, if any.
+func commonKey(a, b []string) (string, bool) {
+	for _, k := range a {
+		for _, l := range b {
+			if k == l {
+				return k, true
+			}
+		}
+	}
+	return "", false
+}
@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
 
 package cmd
 
@@ -25,6 +25,7 @@ import (
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/memory"
 	"github.com/golang/mock/gomock"
+	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
 	"github.com/loderunner/scrt/backend"
@@ -94,6 +95,35 @@ func (f mockFactory) Flags() *pflag.FlagSet {
 	return pflag.NewFlagSet("mock", pflag.ContinueOnError)
 }
 
+func (f mockFactory) Capabilities() []backend.Capability {
+	return backend.Capabilities(f.b)
+}
+
 func newMockFactory(b backend.Backend) mockFactory {
 	return mockFactory{b: b}
 }
+
+type mockConditionalBackend struct {
+	*MockBackend
+	*MockConditionalSaver
+}
+
+func newMockConditionalBackend(ctrl *gomock.Controller) mockConditionalBackend {
+	return mockConditionalBackend{
+		MockBackend:          NewMockBackend(ctrl),
+		MockConditionalSaver: NewMockConditionalSaver(ctrl),
+	}
+}
+
+// setFlag sets a flag of cmd for the duration of the test.
+func setFlag(t *testing.T, cmd *cobra.Command, name, value string) {
+	err := cmd.Flags().Set(name, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f := cmd.Flags().Lookup(name)
+		f.Changed = false
+		_ = f.Value.Set(f.DefValue)
+	})
+}
@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/loderunner/scrt/backend (interfaces: Backend)
+// Source: github.com/loderunner/scrt/backend (interfaces: Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter)
 
 // Package cmd is a generated GoMock package.
 package cmd
@@ -7,8 +7,10 @@ package cmd
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	backend "github.com/loderunner/scrt/backend"
 )
 
 // MockBackend is a mock of Backend interface.
@@ -121,3 +123,439 @@ func (mr *MockBackendMockRecorder) SaveContext(arg0, arg1 interface{}) *gomock.C
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveContext", reflect.TypeOf((*MockBackend)(nil).SaveContext), arg0, arg1)
 }
+
+// MockConditionalSaver is a mock of ConditionalSaver interface.
+type MockConditionalSaver struct {
+	ctrl     *gomock.Controller
+	recorder *MockConditionalSaverMockRecorder
+}
+
+// MockConditionalSaverMockRecorder is the mock recorder for MockConditionalSaver.
+type MockConditionalSaverMockRecorder struct {
+	mock *MockConditionalSaver
+}
+
+// NewMockConditionalSaver creates a new mock instance.
+func NewMockConditionalSaver(ctrl *gomock.Controller) *MockConditionalSaver {
+	mock := &MockConditionalSaver{ctrl: ctrl}
+	mock.recorder = &MockConditionalSaverMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockConditionalSaver) EXPECT() *MockConditionalSaverMockRecorder {
+	return m.recorder
+}
+
+// LoadRevision mocks base method.
+func (m *MockConditionalSaver) LoadRevision() ([]byte, backend.Revision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadRevision")
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(backend.Revision)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// LoadRevision indicates an expected call of LoadRevision.
+func (mr *MockConditionalSaverMockRecorder) LoadRevision() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadRevision", reflect.TypeOf((*MockConditionalSaver)(nil).LoadRevision))
+}
+
+// LoadRevisionContext mocks base method.
+func (m *MockConditionalSaver) LoadRevisionContext(arg0 context.Context) ([]byte, backend.Revision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadRevisionContext", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(backend.Revision)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// LoadRevisionContext indicates an expected call of LoadRevisionContext.
+func (mr *MockConditionalSaverMockRecorder) LoadRevisionContext(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadRevisionContext", reflect.TypeOf((*MockConditionalSaver)(nil).LoadRevisionContext), arg0)
+}
+
+// SaveRevision mocks base method.
+func (m *MockConditionalSaver) SaveRevision(arg0 []byte, arg1 backend.Revision) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRevision", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRevision indicates an expected call of SaveRevision.
+func (mr *MockConditionalSaverMockRecorder) SaveRevision(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRevision", reflect.TypeOf((*MockConditionalSaver)(nil).SaveRevision), arg0, arg1)
+}
+
+// SaveRevisionContext mocks base method.
+func (m *MockConditionalSaver) SaveRevisionContext(arg0 context.Context, arg1 []byte, arg2 backend.Revision) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveRevisionContext", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRevisionContext indicates an expected call of SaveRevisionContext.
+func (mr *MockConditionalSaverMockRecorder) SaveRevisionContext(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRevisionContext", reflect.TypeOf((*MockConditionalSaver)(nil).SaveRevisionContext), arg0, arg1, arg2)
+}
+
+// MockBackupKeeper is a mock of BackupKeeper interface.
+type MockBackupKeeper struct {
+	ctrl     *gomock.Controller
+	recorder *MockBackupKeeperMockRecorder
+}
+
+// MockBackupKeeperMockRecorder is the mock recorder for MockBackupKeeper.
+type MockBackupKeeperMockRecorder struct {
+	mock *MockBackupKeeper
+}
+
+// NewMockBackupKeeper creates a new mock instance.
+func NewMockBackupKeeper(ctrl *gomock.Controller) *MockBackupKeeper {
+	mock := &MockBackupKeeper{ctrl: ctrl}
+	mock.recorder = &MockBackupKeeperMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBackupKeeper) EXPECT() *MockBackupKeeperMockRecorder {
+	return m.recorder
+}
+
+// ListBackups mocks base method.
+func (m *MockBackupKeeper) ListBackups() ([]backend.Backup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBackups")
+	ret0, _ := ret[0].([]backend.Backup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListBackups indicates an expected call of ListBackups.
+func (mr *MockBackupKeeperMockRecorder) ListBackups() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBackups", reflect.TypeOf((*MockBackupKeeper)(nil).ListBackups))
+}
+
+// ListBackupsContext mocks base method.
+func (m *MockBackupKeeper) ListBackupsContext(arg0 context.Context) ([]backend.Backup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListBackupsContext", arg0)
+	ret0, _ := ret[0].([]backend.Backup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListBackupsContext indicates an expected call of ListBackupsContext.
+func (mr *MockBackupKeeperMockRecorder) ListBackupsContext(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListBackupsContext", reflect.TypeOf((*MockBackupKeeper)(nil).ListBackupsContext), arg0)
+}
+
+// LoadBackup mocks base method.
+func (m *MockBackupKeeper) LoadBackup(arg0 int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadBackup", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadBackup indicates an expected call of LoadBackup.
+func (mr *MockBackupKeeperMockRecorder) LoadBackup(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadBackup", reflect.TypeOf((*MockBackupKeeper)(nil).LoadBackup), arg0)
+}
+
+// LoadBackupContext mocks base method.
+func (m *MockBackupKeeper) LoadBackupContext(arg0 context.Context, arg1 int) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadBackupContext", arg0, arg1)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadBackupContext indicates an expected call of LoadBackupContext.
+func (mr *MockBackupKeeperMockRecorder) LoadBackupContext(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadBackupContext", reflect.TypeOf((*MockBackupKeeper)(nil).LoadBackupContext), arg0, arg1)
+}
+
+// MockVersioned is a mock of Versioned interface.
+type MockVersioned struct {
+	ctrl     *gomock.Controller
+	recorder *MockVersionedMockRecorder
+}
+
+// MockVersionedMockRecorder is the mock recorder for MockVersioned.
+type MockVersionedMockRecorder struct {
+	mock *MockVersioned
+}
+
+// NewMockVersioned creates a new mock instance.
+func NewMockVersioned(ctrl *gomock.Controller) *MockVersioned {
+	mock := &MockVersioned{ctrl: ctrl}
+	mock.recorder = &MockVersionedMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockVersioned) EXPECT() *MockVersionedMockRecorder {
+	return m.recorder
+}
+
+// ListVersions mocks base method.
+func (m *MockVersioned) ListVersions() ([]backend.Version, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListVersions")
+	ret0, _ := ret[0].([]backend.Version)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListVersions indicates an expected call of ListVersions.
+func (mr *MockVersionedMockRecorder) ListVersions() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListVersions", reflect.TypeOf((*MockVersioned)(nil).ListVersions))
+}
+
+// ListVersionsContext mocks base method.
+func (m *MockVersioned) ListVersionsContext(arg0 context.Context) ([]backend.Version, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListVersionsContext", arg0)
+	ret0, _ := ret[0].([]backend.Version)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListVersionsContext indicates an expected call of ListVersionsContext.
+func (mr *MockVersionedMockRecorder) ListVersionsContext(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListVersionsContext", reflect.TypeOf((*MockVersioned)(nil).ListVersionsContext), arg0)
+}
+
+// LoadAt mocks base method.
+func (m *MockVersioned) LoadAt(arg0 time.Time) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadAt", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadAt indicates an expected call of LoadAt.
+func (mr *MockVersionedMockRecorder) LoadAt(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadAt", reflect.TypeOf((*MockVersioned)(nil).LoadAt), arg0)
+}
+
+// LoadAtContext mocks base method.
+func (m *MockVersioned) LoadAtContext(arg0 context.Context, arg1 time.Time) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadAtContext", arg0, arg1)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadAtContext indicates an expected call of LoadAtContext.
+func (mr *MockVersionedMockRecorder) LoadAtContext(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadAtContext", reflect.TypeOf((*MockVersioned)(nil).LoadAtContext), arg0, arg1)
+}
+
+// LoadVersion mocks base method.
+func (m *MockVersioned) LoadVersion(arg0 string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadVersion", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadVersion indicates an expected call of LoadVersion.
+func (mr *MockVersionedMockRecorder) LoadVersion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadVersion", reflect.TypeOf((*MockVersioned)(nil).LoadVersion), arg0)
+}
+
+// LoadVersionContext mocks base method.
+func (m *MockVersioned) LoadVersionContext(arg0 context.Context, arg1 string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadVersionContext", arg0, arg1)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadVersionContext indicates an expected call of LoadVersionContext.
+func (mr *MockVersionedMockRecorder) LoadVersionContext(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadVersionContext", reflect.TypeOf((*MockVersioned)(nil).LoadVersionContext), arg0, arg1)
+}
+
+// MockLister is a mock of Lister interface.
+type MockLister struct {
+	ctrl     *gomock.Controller
+	recorder *MockListerMockRecorder
+}
+
+// MockListerMockRecorder is the mock recorder for MockLister.
+type MockListerMockRecorder struct {
+	mock *MockLister
+}
+
+// NewMockLister creates a new mock instance.
+func NewMockLister(ctrl *gomock.Controller) *MockLister {
+	mock := &MockLister{ctrl: ctrl}
+	mock.recorder = &MockListerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLister) EXPECT() *MockListerMockRecorder {
+	return m.recorder
+}
+
+// ListStores mocks base method.
+func (m *MockLister) ListStores() ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListStores")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListStores indicates an expected call of ListStores.
+func (mr *MockListerMockRecorder) ListStores() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListStores", reflect.TypeOf((*MockLister)(nil).ListStores))
+}
+
+// ListStoresContext mocks base method.
+func (m *MockLister) ListStoresContext(arg0 context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListStoresContext", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListStoresContext indicates an expected call of ListStoresContext.
+func (mr *MockListerMockRecorder) ListStoresContext(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListStoresContext", reflect.TypeOf((*MockLister)(nil).ListStoresContext), arg0)
+}
+
+// MockSharer is a mock of Sharer interface.
+type MockSharer struct {
+	ctrl     *gomock.Controller
+	recorder *MockSharerMockRecorder
+}
+
+// MockSharerMockRecorder is the mock recorder for MockSharer.
+type MockSharerMockRecorder struct {
+	mock *MockSharer
+}
+
+// NewMockSharer creates a new mock instance.
+func NewMockSharer(ctrl *gomock.Controller) *MockSharer {
+	mock := &MockSharer{ctrl: ctrl}
+	mock.recorder = &MockSharerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSharer) EXPECT() *MockSharerMockRecorder {
+	return m.recorder
+}
+
+// Share mocks base method.
+func (m *MockSharer) Share(arg0 time.Duration) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Share", arg0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Share indicates an expected call of Share.
+func (mr *MockSharerMockRecorder) Share(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Share", reflect.TypeOf((*MockSharer)(nil).Share), arg0)
+}
+
+// ShareContext mocks base method.
+func (m *MockSharer) ShareContext(arg0 context.Context, arg1 time.Duration) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ShareContext", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ShareContext indicates an expected call of ShareContext.
+func (mr *MockSharerMockRecorder) ShareContext(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ShareContext", reflect.TypeOf((*MockSharer)(nil).ShareContext), arg0, arg1)
+}
+
+// MockDeleter is a mock of Deleter interface.
+type MockDeleter struct {
+	ctrl     *gomock.Controller
+	recorder *MockDeleterMockRecorder
+}
+
+// MockDeleterMockRecorder is the mock recorder for MockDeleter.
+type MockDeleterMockRecorder struct {
+	mock *MockDeleter
+}
+
+// NewMockDeleter creates a new mock instance.
+func NewMockDeleter(ctrl *gomock.Controller) *MockDeleter {
+	mock := &MockDeleter{ctrl: ctrl}
+	mock.recorder = &MockDeleterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDeleter) EXPECT() *MockDeleterMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method.
+func (m *MockDeleter) Delete() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockDeleterMockRecorder) Delete() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockDeleter)(nil).Delete))
+}
+
+// DeleteContext mocks base method.
+func (m *MockDeleter) DeleteContext(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteContext", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteContext indicates an expected call of DeleteContext.
+func (mr *MockDeleterMockRecorder) DeleteContext(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteContext", reflect.TypeOf((*MockDeleter)(nil).DeleteContext), arg0)
+}
@@ -0,0 +1,92 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+	"github.com/loderunner/scrt/store"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [flags]",
+	Short: "Restore a previous version of a store",
+	Long: "Restore a previous version of a store, given by its version ID or" +
+		" by a date. The\nrestored version is saved as the new current" +
+		" version of the store.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		err := cobra.ExactArgs(0)(cmd, args)
+		if err != nil {
+			return err
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage := viper.GetString(configKeyStorage)
+
+		b, err := backend.Backends[storage].NewContext(
+			cmdContext,
+			viper.AllSettings(),
+		)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		unlock, err := lockStore(b)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		data, err := loadVersion(cmd, b, storage, "version-id")
+		if err != nil {
+			return err
+		}
+
+		// Check that the version can be read with the password before
+		// restoring
+		password := []byte(viper.GetString(configKeyPassword))
+		_, err = store.ReadStoreContext(cmdContext, password, data)
+		if err != nil {
+			return fmt.Errorf("could not read store from version: %w", err)
+		}
+
+		ctx := backend.NewChangeContext(
+			cmdContext,
+			backend.Change{Operation: "restore"},
+		)
+		err = b.SaveContext(ctx, data)
+		if err != nil {
+			return fmt.Errorf("could not save data to store: %w", err)
+		}
+
+		fmt.Println("store restored")
+
+		return nil
+	},
+}
+
+func init() {
+	restoreCmd.Flags().String("version-id", "", "ID of the version to restore")
+	restoreCmd.Flags().
+		String("at", "", "restore the store as it was at a date or time")
+	restoreCmd.MarkFlagsMutuallyExclusive("version-id", "at")
+	restoreCmd.MarkFlagsOneRequired("version-id", "at")
+}
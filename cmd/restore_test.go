@@ -0,0 +1,144 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+	"github.com/loderunner/scrt/store"
+)
+
+func TestRestoreCmdVersionID(t *testing.T) {
+	hijack()
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockVersionedBackend{
+		MockBackend:   NewMockBackend(ctrl),
+		MockVersioned: NewMockVersioned(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	password := "toto"
+
+	viper.Reset()
+	viper.Set(configKeyPassword, password)
+	viper.Set(configKeyStorage, "mock")
+
+	data, err := store.WriteStore([]byte(password), store.NewStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setFlag(t, restoreCmd, "version-id", "v1")
+	mockBackend.MockVersioned.EXPECT().
+		LoadVersionContext(ctxMatcher, "v1").
+		Return(data, nil)
+	mockBackend.MockBackend.EXPECT().SaveContext(ctxMatcher, data)
+
+	err = restoreCmd.RunE(restoreCmd, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreCmdAt(t *testing.T) {
+	hijack()
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockVersionedBackend{
+		MockBackend:   NewMockBackend(ctrl),
+		MockVersioned: NewMockVersioned(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	password := "toto"
+
+	viper.Reset()
+	viper.Set(configKeyPassword, password)
+	viper.Set(configKeyStorage, "mock")
+
+	data, err := store.WriteStore([]byte(password), store.NewStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	setFlag(t, restoreCmd, "at", "2024-06-01T12:00:00Z")
+	mockBackend.MockVersioned.EXPECT().
+		LoadAtContext(ctxMatcher, at).
+		Return(data, nil)
+	mockBackend.MockBackend.EXPECT().SaveContext(ctxMatcher, data)
+
+	err = restoreCmd.RunE(restoreCmd, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreCmdInvalidData(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockVersionedBackend{
+		MockBackend:   NewMockBackend(ctrl),
+		MockVersioned: NewMockVersioned(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	setFlag(t, restoreCmd, "version-id", "v1")
+	mockBackend.MockVersioned.EXPECT().
+		LoadVersionContext(ctxMatcher, "v1").
+		Return([]byte("toto"), nil)
+
+	err := restoreCmd.RunE(restoreCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRestoreCmdMissingVersion(t *testing.T) {
+	// Cobra requires one of the version flags before running the command
+	err := restoreCmd.ValidateFlagGroups()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(err.Error(), "version-id") {
+		t.Fatalf("expected missing version error, got %v", err)
+	}
+
+	setFlag(t, restoreCmd, "at", "2023-01-01")
+	err = restoreCmd.ValidateFlagGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -110,15 +110,10 @@ var RootCmd = &cobra.Command{
 				WithField("path", viper.ConfigFileUsed()).
 				Infof("read configuration file")
 		}
-		settings := make(map[string]interface{})
-		for k, v := range viper.AllSettings() {
-			// Do not log password or unset settings
-			if k != configKeyPassword && !reflect.ValueOf(v).IsZero() {
-				settings[k] = v
-			}
-		}
 		logger.
-			WithFields(fielder{fields: settings}).
+			WithFields(fielder{
+				fields: redactSettings(viper.AllSettings()),
+			}).
 			Info("using configuration")
 
 		// Silence usage on error, since errors are runtime, not config, from
@@ -129,6 +124,47 @@ var RootCmd = &cobra.Command{
 	},
 }
 
+// redacted replaces the values of secret settings in logs.
+const redacted = "<redacted>"
+
+// redactSettings returns the settings to log: the password and unset settings
+// are left out, and the values of the flags marked as secret by the backends
+// are redacted, in their flat (e.g. "s3-session-token") and nested (e.g. "s3"
+// > "session-token") forms. The flags of every backend are looked up, since
+// the configuration may hold settings of backends other than the selected one.
+func redactSettings(settings map[string]interface{}) map[string]interface{} {
+	isSecret := func(name string) bool {
+		for _, b := range backend.Backends {
+			f := b.Flags().Lookup(name)
+			if f != nil && backend.IsSecret(f) {
+				return true
+			}
+		}
+		return false
+	}
+
+	redactedSettings := make(map[string]interface{})
+	for k, v := range settings {
+		if k == configKeyPassword || reflect.ValueOf(v).IsZero() {
+			continue
+		}
+		if isSecret(k) {
+			v = redacted
+		} else if opts, ok := v.(map[string]interface{}); ok {
+			nested := make(map[string]interface{})
+			for name, opt := range opts {
+				if isSecret(k + "-" + name) {
+					opt = redacted
+				}
+				nested[name] = opt
+			}
+			v = nested
+		}
+		redactedSettings[k] = v
+	}
+	return redactedSettings
+}
+
 func readConfig(_ *cobra.Command) error {
 	if configFile != "" {
 		viper.SetConfigFile(configFile)
@@ -175,6 +211,12 @@ func init() {
 	addCommand(getCmd)
 	addCommand(listCmd)
 	addCommand(unsetCmd)
+	addCommand(backupsCmd)
+	addCommand(versionsCmd)
+	addCommand(restoreCmd)
+	addCommand(storesCmd)
+	addCommand(shareCmd)
+	addCommand(destroyCmd)
 	addCommand(storageCmd)
 
 	RootCmd.PersistentFlags().
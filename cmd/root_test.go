@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -53,3 +54,54 @@ func TestRootCmd(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRedactSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		configKeyPassword:      "p4ssw0rd",
+		configKeyStorage:       "s3",
+		"s3-bucket-name":       "",
+		"s3-secret-access-key": "SUPERSECRET",
+		"s3": map[string]interface{}{
+			"bucket-name":   "scrt-bucket",
+			"session-token": "T0K3N",
+		},
+	}
+	want := map[string]interface{}{
+		configKeyStorage:       "s3",
+		"s3-secret-access-key": redacted,
+		"s3": map[string]interface{}{
+			"bucket-name":   "scrt-bucket",
+			"session-token": redacted,
+		},
+	}
+	got := redactSettings(settings)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+
+	// Secrets of the backends that are not selected
+	settings = map[string]interface{}{
+		configKeyStorage: "git",
+		"http-token":     "HTTPTOKEN",
+		"git": map[string]interface{}{
+			"url": "https://example.com/repo.git",
+		},
+		"s3": map[string]interface{}{
+			"secret-access-key": "S3SECRET",
+		},
+	}
+	want = map[string]interface{}{
+		configKeyStorage: "git",
+		"http-token":     redacted,
+		"git": map[string]interface{}{
+			"url": "https://example.com/repo.git",
+		},
+		"s3": map[string]interface{}{
+			"secret-access-key": redacted,
+		},
+	}
+	got = redactSettings(settings)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+}
@@ -66,6 +66,13 @@ var setCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		defer closeBackend(b)
+
+		unlock, err := lockStore(b)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 
 		exists, err := b.ExistsContext(cmdContext)
 		if err != nil {
@@ -75,17 +82,6 @@ var setCmd = &cobra.Command{
 			return fmt.Errorf("store does not exist")
 		}
 
-		data, err := b.LoadContext(cmdContext)
-		if err != nil {
-			return fmt.Errorf("could not load data from store: %w", err)
-		}
-
-		password := []byte(viper.GetString(configKeyPassword))
-		s, err := store.ReadStoreContext(cmdContext, password, data)
-		if err != nil {
-			return fmt.Errorf("could not read store from data: %w", err)
-		}
-
 		var overwrite bool
 		if cmd.Flags().Changed("overwrite") {
 			overwrite, err = cmd.Flags().GetBool("overwrite")
@@ -94,32 +90,25 @@ var setCmd = &cobra.Command{
 			}
 		}
 
-		if s.HasContext(cmdContext, key) {
-			if !overwrite {
-				return fmt.Errorf(
-					"value exists for key \"%s\", use --overwrite to force",
-					key,
-				)
+		password := []byte(viper.GetString(configKeyPassword))
+		change := backend.Change{Operation: "set", Keys: []string{key}}
+		return updateStore(b, password, change, func(s store.Store) error {
+			if s.HasContext(cmdContext, key) {
+				if !overwrite {
+					return fmt.Errorf(
+						"value exists for key \"%s\", use --overwrite to force",
+						key,
+					)
+				}
+				logger.WithField("key", key).Info("overwriting existing value")
 			}
-			logger.WithField("key", key).Info("overwriting existing value")
-		}
 
-		err = s.SetContext(cmdContext, key, val)
-		if err != nil {
-			return fmt.Errorf("could not set value: %w", err)
-		}
-
-		data, err = store.WriteStoreContext(cmdContext, password, s)
-		if err != nil {
-			return fmt.Errorf("could not write store to data: %w", err)
-		}
-
-		err = b.SaveContext(cmdContext, data)
-		if err != nil {
-			return fmt.Errorf("could not save data to store: %w", err)
-		}
-
-		return nil
+			err := s.SetContext(cmdContext, key, val)
+			if err != nil {
+				return fmt.Errorf("could not set value: %w", err)
+			}
+			return nil
+		})
 	},
 }
 
@@ -12,12 +12,16 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
 
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -31,7 +35,7 @@ func TestSetCmd(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	password := "toto"
@@ -46,9 +50,25 @@ func TestSetCmd(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
-	mockBackend.EXPECT().SaveContext(ctxMatcher, gomock.Any())
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		SaveRevisionContext(ctxMatcher, gomock.Any(), backend.Revision("1")).
+		DoAndReturn(
+			func(ctx context.Context, _ []byte, _ backend.Revision) error {
+				change, _ := backend.ChangeFromContext(ctx)
+				want := backend.Change{
+					Operation: "set",
+					Keys:      []string{"hello"},
+				}
+				if !reflect.DeepEqual(change, want) {
+					t.Errorf("expected %#v, got %#v", want, change)
+				}
+				return nil
+			},
+		)
 
 	args := []string{"hello", "world"}
 	err = setCmd.Args(setCmd, args)
@@ -69,7 +89,7 @@ func TestSetCmdStdin(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	password := "toto"
@@ -84,9 +104,12 @@ func TestSetCmdStdin(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
-	mockBackend.EXPECT().SaveContext(ctxMatcher, gomock.Any())
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		SaveRevisionContext(ctxMatcher, gomock.Any(), backend.Revision("1"))
 
 	_, err = hijackStdin.WriteString("world")
 	if err != nil {
@@ -105,18 +128,55 @@ func TestSetCmdStdin(t *testing.T) {
 	}
 }
 
-func TestSetCmdNotExists(t *testing.T) {
+func TestSetCmdNotConditional(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
 	mockBackend := NewMockBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
+	password := "toto"
+
+	viper.Reset()
+	viper.Set(configKeyPassword, password)
+	viper.Set(configKeyStorage, "mock")
+
+	s := store.NewStore()
+	data, err := store.WriteStore([]byte(password), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without conditional saves, the store is loaded and saved as is
+	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
+	mockBackend.EXPECT().SaveContext(ctxMatcher, gomock.Any())
+
+	args := []string{"hello", "world"}
+	err = setCmd.Args(setCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setCmd.RunE(setCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetCmdNotExists(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := newMockConditionalBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
 	viper.Reset()
 	viper.Set(configKeyPassword, "toto")
 	viper.Set(configKeyStorage, "mock")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(false, nil)
+	mockBackend.MockBackend.EXPECT().
+		ExistsContext(ctxMatcher).
+		Return(false, nil)
 
 	args := []string{"hello", "world"}
 	err := setCmd.Args(setCmd, args)
@@ -133,17 +193,17 @@ func TestSetCmdFailedLoad(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	viper.Reset()
 	viper.Set(configKeyPassword, "toto")
 	viper.Set(configKeyStorage, "mock")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().
-		LoadContext(ctxMatcher).
-		Return(nil, fmt.Errorf("error"))
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(nil, backend.Revision(""), fmt.Errorf("error"))
 
 	args := []string{"hello", "world"}
 	err := setCmd.Args(setCmd, args)
@@ -160,7 +220,7 @@ func TestSetCmdFailedInvalidData(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	viper.Reset()
@@ -169,8 +229,10 @@ func TestSetCmdFailedInvalidData(t *testing.T) {
 
 	data := []byte("toto")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
 
 	args := []string{"hello", "world"}
 	err := setCmd.Args(setCmd, args)
@@ -187,7 +249,7 @@ func TestSetCmdFailedNoOverwrite(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	password := "toto"
@@ -206,8 +268,10 @@ func TestSetCmdFailedNoOverwrite(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
 
 	args := []string{"hello", "world"}
 	err = setCmd.Args(setCmd, args)
@@ -224,7 +288,7 @@ func TestSetCmdOverwrite(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	password := "toto"
@@ -243,9 +307,12 @@ func TestSetCmdOverwrite(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
-	mockBackend.EXPECT().SaveContext(ctxMatcher, gomock.Any())
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		SaveRevisionContext(ctxMatcher, gomock.Any(), backend.Revision("1"))
 
 	err = setCmd.Flags().Set("overwrite", "true")
 	if err != nil {
@@ -266,7 +333,7 @@ func TestSetCmdFailedSave(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	password := "toto"
@@ -281,10 +348,12 @@ func TestSetCmdFailedSave(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
-	mockBackend.EXPECT().
-		SaveContext(ctxMatcher, gomock.Any()).
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		SaveRevisionContext(ctxMatcher, gomock.Any(), backend.Revision("1")).
 		Return(fmt.Errorf("error"))
 
 	args := []string{"hello", "world"}
@@ -297,3 +366,182 @@ func TestSetCmdFailedSave(t *testing.T) {
 		t.Fatal("expected error")
 	}
 }
+
+func TestSetCmdConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := newMockConditionalBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	password := "toto"
+
+	viper.Reset()
+	viper.Set(configKeyPassword, password)
+	viper.Set(configKeyStorage, "mock")
+
+	s := store.NewStore()
+	data, err := store.WriteStore([]byte(password), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another value was set concurrently
+	err = s.Set("bonjour", []byte("monde"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updatedData, err := store.WriteStore([]byte(password), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var savedData []byte
+	rev1, rev2 := backend.Revision("1"), backend.Revision("2")
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	gomock.InOrder(
+		mockBackend.MockConditionalSaver.EXPECT().
+			LoadRevisionContext(ctxMatcher).
+			Return(data, rev1, nil),
+		mockBackend.MockConditionalSaver.EXPECT().
+			SaveRevisionContext(ctxMatcher, gomock.Any(), rev1).
+			Return(&backend.ConflictError{}),
+		mockBackend.MockConditionalSaver.EXPECT().
+			LoadRevisionContext(ctxMatcher).
+			Return(updatedData, rev2, nil),
+		mockBackend.MockConditionalSaver.EXPECT().
+			SaveRevisionContext(ctxMatcher, gomock.Any(), rev2).
+			DoAndReturn(
+				func(_ context.Context, data []byte, _ backend.Revision) error {
+					savedData = data
+					return nil
+				},
+			),
+	)
+
+	args := []string{"hello", "world"}
+	err = setCmd.Args(setCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setCmd.RunE(setCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = store.ReadStore([]byte(password), savedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Has("hello") || !s.Has("bonjour") {
+		t.Fatalf("expected both keys in store, got %v", s.List())
+	}
+}
+
+func TestSetCmdConflictRetriesExhausted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := newMockConditionalBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	password := "toto"
+
+	viper.Reset()
+	viper.Set(configKeyPassword, password)
+	viper.Set(configKeyStorage, "mock")
+
+	s := store.NewStore()
+	data, err := store.WriteStore([]byte(password), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil).
+		Times(maxConflictRetries + 1)
+	mockBackend.MockConditionalSaver.EXPECT().
+		SaveRevisionContext(ctxMatcher, gomock.Any(), backend.Revision("1")).
+		Return(&backend.ConflictError{}).
+		Times(maxConflictRetries + 1)
+
+	args := []string{"hello", "world"}
+	err = setCmd.Args(setCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setCmd.RunE(setCmd, args)
+	var conflictErr *backend.ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+}
+
+func TestSetCmdConflictSameKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := newMockConditionalBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	password := "toto"
+
+	viper.Reset()
+	viper.Set(configKeyPassword, password)
+	viper.Set(configKeyStorage, "mock")
+
+	s := store.NewStore()
+	err := s.Set("hello", []byte("world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.WriteStore([]byte(password), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same key was set concurrently
+	err = s.Set("hello", []byte("monde"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updatedData, err := store.WriteStore([]byte(password), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rev1, rev2 := backend.Revision("1"), backend.Revision("2")
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	gomock.InOrder(
+		mockBackend.MockConditionalSaver.EXPECT().
+			LoadRevisionContext(ctxMatcher).
+			Return(data, rev1, nil),
+		mockBackend.MockConditionalSaver.EXPECT().
+			SaveRevisionContext(ctxMatcher, gomock.Any(), rev1).
+			Return(&backend.ConflictError{}),
+		mockBackend.MockConditionalSaver.EXPECT().
+			LoadRevisionContext(ctxMatcher).
+			Return(updatedData, rev2, nil),
+	)
+
+	args := []string{"hello", "le monde"}
+	err = setCmd.Flags().Set("overwrite", "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = setCmd.Flags().Set("overwrite", "false") }()
+	err = setCmd.Args(setCmd, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setCmd.RunE(setCmd, args)
+	var conflictErr *backend.ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "hello") {
+		t.Fatalf("expected error to name the key, got %v", err)
+	}
+}
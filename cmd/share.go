@@ -0,0 +1,87 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+)
+
+var shareCmd = &cobra.Command{
+	Use:   "share [flags]",
+	Short: "Share a store with a temporary URL",
+	Long: "Print a URL giving read access to the encrypted store, until it" +
+		" expires. The store\ncan be read from the URL with the shared" +
+		" storage type, and the password.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		err := cobra.ExactArgs(0)(cmd, args)
+		if err != nil {
+			return err
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage := viper.GetString(configKeyStorage)
+
+		expires, err := cmd.Flags().GetDuration("expires")
+		if err != nil {
+			return err
+		}
+
+		b, err := backend.Backends[storage].NewContext(
+			cmdContext,
+			viper.AllSettings(),
+		)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		s, ok := b.(backend.Sharer)
+		if !ok {
+			return fmt.Errorf("%s storage cannot share stores", storage)
+		}
+
+		exists, err := b.ExistsContext(cmdContext)
+		if err != nil {
+			return fmt.Errorf("could not check store existence: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("store does not exist")
+		}
+
+		url, err := s.ShareContext(cmdContext, expires)
+		if err != nil {
+			return fmt.Errorf("could not share store: %w", err)
+		}
+
+		fmt.Println(url)
+
+		return nil
+	},
+}
+
+func init() {
+	shareCmd.Flags().Duration(
+		"expires",
+		time.Hour,
+		"how long the URL gives access to the store",
+	)
+}
@@ -0,0 +1,117 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+)
+
+type mockSharerBackend struct {
+	*MockBackend
+	*MockSharer
+}
+
+func TestShareCmd(t *testing.T) {
+	hijack()
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockSharerBackend{
+		MockBackend: NewMockBackend(ctrl),
+		MockSharer:  NewMockSharer(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+	setFlag(t, shareCmd, "expires", "30m")
+
+	url := "https://test-bucket.s3.amazonaws.com/store.scrt?X-Amz-Expires=1800"
+	mockBackend.MockBackend.EXPECT().
+		ExistsContext(ctxMatcher).
+		Return(true, nil)
+	mockBackend.MockSharer.EXPECT().
+		ShareContext(ctxMatcher, 30*time.Minute).
+		Return(url, nil)
+
+	err := shareCmd.RunE(shareCmd, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Stdout.Close()
+	data, err := io.ReadAll(hijackStdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != url {
+		t.Fatalf("expected %q, got %q", url, data)
+	}
+}
+
+func TestShareCmdNotExists(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockSharerBackend{
+		MockBackend: NewMockBackend(ctrl),
+		MockSharer:  NewMockSharer(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	mockBackend.MockBackend.EXPECT().
+		ExistsContext(ctxMatcher).
+		Return(false, nil)
+
+	err := shareCmd.RunE(shareCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestShareCmdUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := NewMockBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	err := shareCmd.RunE(shareCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
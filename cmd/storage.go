@@ -17,6 +17,7 @@ package cmd
 import (
 	"fmt"
 	"math"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -58,6 +59,15 @@ var storageCmd = &cobra.Command{
 				factory.Description(),
 			)
 
+			caps := factory.Capabilities()
+			if len(caps) > 0 {
+				names := make([]string, len(caps))
+				for i, c := range caps {
+					names[i] = string(c)
+				}
+				fmt.Printf("Capabilities:\n  %s\n", strings.Join(names, ", "))
+			}
+
 			flags := factory.Flags()
 			flagCount := 0
 			flags.VisitAll(func(_ *pflag.Flag) { flagCount++ })
@@ -17,6 +17,7 @@ package cmd
 import (
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -43,4 +44,7 @@ func TestStorageCmd(t *testing.T) {
 	if len(data) == 0 {
 		t.Fatal("no output")
 	}
+	if !strings.Contains(string(data), "conditional-save") {
+		t.Fatalf("capabilities not listed in output:\n%s", data)
+	}
 }
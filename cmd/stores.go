@@ -0,0 +1,67 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+)
+
+var storesCmd = &cobra.Command{
+	Use:   "stores",
+	Short: "List the stores next to the configured store",
+	Long: "List the stores next to the configured store, e.g. in the same" +
+		" directory or under\nthe same prefix. The stores are listed as they" +
+		" can be given in the storage\nconfiguration.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		err := cobra.ExactArgs(0)(cmd, args)
+		if err != nil {
+			return err
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage := viper.GetString(configKeyStorage)
+
+		b, err := backend.Backends[storage].NewContext(
+			cmdContext,
+			viper.AllSettings(),
+		)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		l, ok := b.(backend.Lister)
+		if !ok {
+			return fmt.Errorf("%s storage cannot list stores", storage)
+		}
+
+		stores, err := l.ListStoresContext(cmdContext)
+		if err != nil {
+			return fmt.Errorf("could not list stores: %w", err)
+		}
+
+		for _, s := range stores {
+			fmt.Println(s)
+		}
+
+		return nil
+	},
+}
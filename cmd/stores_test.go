@@ -0,0 +1,90 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+)
+
+type mockListerBackend struct {
+	*MockBackend
+	*MockLister
+}
+
+func TestStoresCmd(t *testing.T) {
+	hijack()
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockListerBackend{
+		MockBackend: NewMockBackend(ctrl),
+		MockLister:  NewMockLister(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	stores := []string{"a/dev.scrt", "a/prod.scrt"}
+	mockBackend.MockLister.EXPECT().
+		ListStoresContext(ctxMatcher).
+		Return(stores, nil)
+
+	err := storesCmd.RunE(storesCmd, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Stdout.Close()
+	data, err := io.ReadAll(hijackStdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !reflect.DeepEqual(stores, lines) {
+		t.Fatalf("expected %#v, got %#v", stores, lines)
+	}
+}
+
+func TestStoresCmdUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := NewMockBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	err := storesCmd.RunE(storesCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -45,6 +45,13 @@ var unsetCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		defer closeBackend(b)
+
+		unlock, err := lockStore(b)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 
 		exists, err := b.ExistsContext(cmdContext)
 		if err != nil {
@@ -54,29 +61,11 @@ var unsetCmd = &cobra.Command{
 			return fmt.Errorf("store does not exist")
 		}
 
-		data, err := b.LoadContext(cmdContext)
-		if err != nil {
-			return fmt.Errorf("could not load data from store: %w", err)
-		}
-
 		password := []byte(viper.GetString(configKeyPassword))
-		s, err := store.ReadStoreContext(cmdContext, password, data)
-		if err != nil {
-			return fmt.Errorf("could not read store from data: %w", err)
-		}
-
-		s.UnsetContext(cmdContext, key)
-
-		data, err = store.WriteStoreContext(cmdContext, password, s)
-		if err != nil {
-			return fmt.Errorf("could not write store to data: %w", err)
-		}
-
-		err = b.SaveContext(cmdContext, data)
-		if err != nil {
-			return fmt.Errorf("could not save data to store: %w", err)
-		}
-
-		return nil
+		change := backend.Change{Operation: "unset", Keys: []string{key}}
+		return updateStore(b, password, change, func(s store.Store) error {
+			s.UnsetContext(cmdContext, key)
+			return nil
+		})
 	},
 }
@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
 
 package cmd
 
@@ -31,7 +31,7 @@ func TestUnsetCmd(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	password := "toto"
@@ -50,9 +50,12 @@ func TestUnsetCmd(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
-	mockBackend.EXPECT().SaveContext(ctxMatcher, gomock.Any())
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		SaveRevisionContext(ctxMatcher, gomock.Any(), backend.Revision("1"))
 
 	args := []string{"hello"}
 	err = unsetCmd.Args(unsetCmd, args)
@@ -69,7 +72,7 @@ func TestUnsetCmdNoValue(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	password := "toto"
@@ -84,9 +87,12 @@ func TestUnsetCmdNoValue(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
-	mockBackend.EXPECT().SaveContext(ctxMatcher, gomock.Any())
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		SaveRevisionContext(ctxMatcher, gomock.Any(), backend.Revision("1"))
 
 	args := []string{"hello"}
 	err = unsetCmd.Args(unsetCmd, args)
@@ -103,14 +109,16 @@ func TestUnsetCmdNotExists(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	viper.Reset()
 	viper.Set(configKeyPassword, "toto")
 	viper.Set(configKeyStorage, "mock")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(false, nil)
+	mockBackend.MockBackend.EXPECT().
+		ExistsContext(ctxMatcher).
+		Return(false, nil)
 
 	args := []string{"hello"}
 	err := unsetCmd.Args(unsetCmd, args)
@@ -127,17 +135,17 @@ func TestUnsetCmdFailedLoad(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	viper.Reset()
 	viper.Set(configKeyPassword, "toto")
 	viper.Set(configKeyStorage, "mock")
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().
-		LoadContext(ctxMatcher).
-		Return(nil, fmt.Errorf("error"))
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(nil, backend.Revision(""), fmt.Errorf("error"))
 
 	args := []string{"hello"}
 	err := unsetCmd.Args(unsetCmd, args)
@@ -154,7 +162,7 @@ func TestUnsetCmdFailedSave(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	mockBackend := NewMockBackend(ctrl)
+	mockBackend := newMockConditionalBackend(ctrl)
 	backend.Backends["mock"] = newMockFactory(mockBackend)
 
 	password := "toto"
@@ -169,10 +177,12 @@ func TestUnsetCmdFailedSave(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	mockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
-	mockBackend.EXPECT().LoadContext(ctxMatcher).Return(data, nil)
-	mockBackend.EXPECT().
-		SaveContext(ctxMatcher, gomock.Any()).
+	mockBackend.MockBackend.EXPECT().ExistsContext(ctxMatcher).Return(true, nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		LoadRevisionContext(ctxMatcher).
+		Return(data, backend.Revision("1"), nil)
+	mockBackend.MockConditionalSaver.EXPECT().
+		SaveRevisionContext(ctxMatcher, gomock.Any(), backend.Revision("1")).
 		Return(fmt.Errorf("error"))
 
 	args := []string{"hello"}
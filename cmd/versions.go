@@ -0,0 +1,76 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+)
+
+var versionsCmd = &cobra.Command{
+	Use:   "versions",
+	Short: "List the versions of a store",
+	Long: "List the versions of a store, from the most recent to the oldest." +
+		" Versions are\nkept by storage types keeping the history of the" +
+		" store, e.g. git repositories\nor versioned S3 buckets.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		err := cobra.ExactArgs(0)(cmd, args)
+		if err != nil {
+			return err
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		storage := viper.GetString(configKeyStorage)
+
+		b, err := backend.Backends[storage].NewContext(
+			cmdContext,
+			viper.AllSettings(),
+		)
+		if err != nil {
+			return err
+		}
+		defer closeBackend(b)
+
+		v, ok := b.(backend.Versioned)
+		if !ok {
+			return fmt.Errorf("%s storage does not keep versions", storage)
+		}
+
+		versions, err := v.ListVersionsContext(cmdContext)
+		if err != nil {
+			return fmt.Errorf("could not list versions: %w", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, version := range versions {
+			_, _ = fmt.Fprintf(
+				w,
+				"%s\t%s\t%d bytes\n",
+				version.ID,
+				version.ModTime.Format(time.RFC3339),
+				version.Size,
+			)
+		}
+		return w.Flush()
+	},
+}
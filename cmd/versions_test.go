@@ -0,0 +1,93 @@
+// Copyright 2021-2023 Charles Francoise
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:generate mockgen -destination mock_backend.go -package cmd "github.com/loderunner/scrt/backend" Backend,ConditionalSaver,BackupKeeper,Versioned,Lister,Sharer,Deleter
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/spf13/viper"
+
+	"github.com/loderunner/scrt/backend"
+)
+
+func TestVersionsCmd(t *testing.T) {
+	hijack()
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := mockVersionedBackend{
+		MockBackend:   NewMockBackend(ctrl),
+		MockVersioned: NewMockVersioned(ctrl),
+	}
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	versions := []backend.Version{
+		{ID: "v2", ModTime: time.Now(), Size: 56},
+		{ID: "v1", ModTime: time.Now(), Size: 46},
+	}
+	mockBackend.MockVersioned.EXPECT().
+		ListVersionsContext(ctxMatcher).
+		Return(versions, nil)
+
+	err := versionsCmd.RunE(versionsCmd, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = os.Stdout.Close()
+	data, err := io.ReadAll(hijackStdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, versions[i].ID+" ") {
+			t.Errorf("unexpected line: %s", line)
+		}
+	}
+}
+
+func TestVersionsCmdUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockBackend := NewMockBackend(ctrl)
+	backend.Backends["mock"] = newMockFactory(mockBackend)
+
+	viper.Reset()
+	viper.Set(configKeyPassword, "toto")
+	viper.Set(configKeyStorage, "mock")
+
+	err := versionsCmd.RunE(versionsCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -165,6 +165,12 @@ const navbar: NavbarConfig = [
           '/reference/commands/set.md',
           '/reference/commands/get.md',
           '/reference/commands/unset.md',
+          '/reference/commands/backups.md',
+          '/reference/commands/versions.md',
+          '/reference/commands/restore.md',
+          '/reference/commands/stores.md',
+          '/reference/commands/share.md',
+          '/reference/commands/destroy.md',
         ],
       },
       {
@@ -173,6 +179,10 @@ const navbar: NavbarConfig = [
           '/reference/storage/local.md',
           '/reference/storage/s3.md',
           '/reference/storage/git.md',
+          '/reference/storage/sftp.md',
+          '/reference/storage/http.md',
+          '/reference/storage/azblob.md',
+          '/reference/storage/shared.md',
         ],
       },
       {
@@ -212,6 +222,12 @@ const sidebar: SidebarConfig = {
             '/reference/commands/set.md',
             '/reference/commands/get.md',
             '/reference/commands/unset.md',
+            '/reference/commands/backups.md',
+            '/reference/commands/versions.md',
+            '/reference/commands/restore.md',
+            '/reference/commands/stores.md',
+            '/reference/commands/share.md',
+            '/reference/commands/destroy.md',
           ],
         },
         {
@@ -220,6 +236,10 @@ const sidebar: SidebarConfig = {
             '/reference/storage/local.md',
             '/reference/storage/s3.md',
             '/reference/storage/git.md',
+            '/reference/storage/sftp.md',
+            '/reference/storage/http.md',
+            '/reference/storage/azblob.md',
+            '/reference/storage/shared.md',
           ],
         },
         '/reference/configuration/README.md',
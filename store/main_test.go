@@ -256,3 +256,41 @@ func TestSetList(t *testing.T) {
 		t.Fatalf("expected %#v, got %#v", keys, res)
 	}
 }
+
+func TestDiff(t *testing.T) {
+	s := NewStore()
+	other := NewStore()
+
+	res := s.Diff(other)
+	if len(res) != 0 {
+		t.Fatalf("expected no difference, got %#v", res)
+	}
+
+	for _, st := range []Store{s, other} {
+		for _, k := range []string{"same", "changed", "unset"} {
+			err := st.Set(k, []byte(k))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	err := other.Set("changed", []byte("new value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Unset("unset")
+	err = other.Set("added", []byte("added"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"added", "changed", "unset"}
+	res = s.Diff(other)
+	if !reflect.DeepEqual(expected, res) {
+		t.Fatalf("expected %#v, got %#v", expected, res)
+	}
+	res = other.Diff(s)
+	if !reflect.DeepEqual(expected, res) {
+		t.Fatalf("expected %#v, got %#v", expected, res)
+	}
+}
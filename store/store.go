@@ -15,8 +15,10 @@
 package store
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"sort"
 )
 
 // Store defines a key-value storage in scrt.
@@ -115,3 +117,28 @@ func (s Store) UnsetContext(ctx context.Context, key string) {
 	logger.WithField("key", key).Info("unsetting value for key")
 	delete(s.data, key)
 }
+
+// Diff returns the sorted keys whose values differ between s and other,
+// including keys that are only in one of the stores.
+func (s Store) Diff(other Store) []string {
+	return s.DiffContext(context.Background(), other)
+}
+
+// DiffContext performs Diff with a context.
+func (s Store) DiffContext(ctx context.Context, other Store) []string {
+	logger := getLogger(ctx)
+	logger.Info("comparing stores")
+	keys := []string{}
+	for k, v := range s.data {
+		if w, ok := other.data[k]; !ok || !bytes.Equal(v, w) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range other.data {
+		if _, ok := s.data[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}